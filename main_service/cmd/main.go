@@ -2,19 +2,49 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"restaurant_reviews/config"
 	"restaurant_reviews/database"
+	"restaurant_reviews/internal/handlers"
+	"restaurant_reviews/internal/jwtAuth"
+	"restaurant_reviews/internal/nlp"
+	"restaurant_reviews/internal/password"
+	"restaurant_reviews/internal/scoring"
 	"restaurant_reviews/routes"
+	"syscall"
 	"time"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to an optional YAML config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	err = jwtAuth.Configure(cfg.JWT)
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+	password.SetParams(password.Params{
+		Memory:      cfg.Password.Memory,
+		Iterations:  cfg.Password.Iterations,
+		Parallelism: cfg.Password.Parallelism,
+		SaltLength:  password.DefaultParams.SaltLength,
+		KeyLength:   password.DefaultParams.KeyLength,
+	})
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// Connect to MongoDB
-	err := database.ConnectMongo(ctx)
+	err = database.ConnectMongo(ctx, cfg.Mongo)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -25,16 +55,59 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	database.SetRanking(cfg.Rating.DecayHalfLife, cfg.Rating.PriorWeight)
+	database.SetFlagThreshold(cfg.Reviews.FlagThreshold)
+	err = database.EnsureRatingAggregates()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = database.FailInterruptedRescoreJobs()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Stop background work on SIGINT/SIGTERM
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = nlp.Configure(runCtx, cfg.NLP)
+	if err != nil {
+		log.Fatal("Failed to configure NLP:", err)
+	}
+
+	err = scoring.Configure(cfg.Rating)
+	if err != nil {
+		log.Fatal("Failed to configure rating policy:", err)
+	}
+
+	scoring.StartWorkers(runCtx, cfg.NLP)
+	handlers.SetReviewPolicy(cfg.Reviews.Mode, cfg.Reviews.Cooldown)
+
 	r := routes.SetupRoutes()
+	err = r.SetTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatal("Failed to set trusted proxies:", err)
+	}
 
 	srv := &http.Server{
-		Addr:         ":8080",
+		Addr:         cfg.Server.Addr(),
 		Handler:      r,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	go func() {
+		<-runCtx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down server: %v", err)
+		}
+	}()
+
 	log.Printf("Starting server on %s", srv.Addr)
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
@@ -0,0 +1,507 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Mongo    MongoConfig    `yaml:"mongo"`
+	JWT      JWTConfig      `yaml:"jwt"`
+	NLP      NLPConfig      `yaml:"nlp"`
+	Rating   RatingConfig   `yaml:"rating"`
+	Reviews  ReviewsConfig  `yaml:"reviews"`
+	Password PasswordConfig `yaml:"password"`
+}
+
+// ServerConfig sets where the API listens. TrustedProxies lists the addresses or
+// CIDRs of proxies whose X-Forwarded-For header gives the client IP; with none the
+// client IP is the address of the connection.
+type ServerConfig struct {
+	Port           int      `yaml:"port"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type MongoConfig struct {
+	URI      string `yaml:"uri"`
+	Database string `yaml:"database"`
+}
+
+// JWTConfig selects how access tokens are signed. HS512 uses Secret, RS256 and EdDSA
+// load every *.pem in KeysDir for verification and sign with ActiveKeyID.
+type JWTConfig struct {
+	Algorithm   string        `yaml:"algorithm"`
+	Secret      string        `yaml:"secret"`
+	KeysDir     string        `yaml:"keys_dir"`
+	ActiveKeyID string        `yaml:"active_key_id"`
+	AccessTTL   time.Duration `yaml:"access_ttl"`
+	RefreshTTL  time.Duration `yaml:"refresh_ttl"`
+}
+
+// NLPConfig selects the review scorer and controls the background scoring workers.
+// Provider is "http" for the NLP service, "lexicon" for the built-in scorer or
+// "auto" for the service with the built-in scorer as fallback, probed every
+// HealthInterval. A failed job is retried after RetryBackoff, doubling up to
+// MaxRetryBackoff, until MaxAttempts is reached. Each call to the service is retried
+// Retries times on transient errors, and BreakerThreshold failed calls in a row stop
+// calls to the service for BreakerCooldown. Review text longer than MaxTextLength
+// characters is refused before it reaches any scorer. Bulk scoring sends BatchSize
+// texts per request with up to BatchConcurrency requests in flight; a batch request
+// may take Timeout plus BatchTextTimeout for every text in it.
+type NLPConfig struct {
+	Provider         string        `yaml:"provider"`
+	URL              string        `yaml:"url"`
+	HealthInterval   time.Duration `yaml:"health_interval"`
+	Timeout          time.Duration `yaml:"timeout"`
+	MaxTextLength    int           `yaml:"max_text_length"`
+	BatchSize        int           `yaml:"batch_size"`
+	BatchConcurrency int           `yaml:"batch_concurrency"`
+	BatchTextTimeout time.Duration `yaml:"batch_text_timeout"`
+	Retries          int           `yaml:"retries"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+	Workers          int           `yaml:"workers"`
+	MaxAttempts      int           `yaml:"max_attempts"`
+	RetryBackoff     time.Duration `yaml:"retry_backoff"`
+	MaxRetryBackoff  time.Duration `yaml:"max_retry_backoff"`
+}
+
+// RatingConfig selects how the user's stars and the NLP rating are combined. Policy
+// is "weighted" (UserWeight of the user's stars, the rest NLP), "user", "nlp" or
+// "penalised", which works like weighted but lowers the result by Penalty per star
+// the two ratings differ beyond DisagreementThreshold. For ranking, a review's
+// weight halves every DecayHalfLife and PriorWeight reviews at the overall mean
+// are added to every restaurant.
+type RatingConfig struct {
+	Policy                string        `yaml:"policy"`
+	UserWeight            float64       `yaml:"user_weight"`
+	DisagreementThreshold float64       `yaml:"disagreement_threshold"`
+	Penalty               float64       `yaml:"penalty"`
+	DecayHalfLife         time.Duration `yaml:"decay_half_life"`
+	PriorWeight           float64       `yaml:"prior_weight"`
+}
+
+// ReviewsConfig decides what happens when a user reviews a restaurant again. In
+// "single" mode their one review is revised, in "per_visit" mode a new review is
+// added once Cooldown has passed since their last one. A published review reported
+// by FlagThreshold users is held for moderation.
+type ReviewsConfig struct {
+	Mode          string        `yaml:"mode"`
+	Cooldown      time.Duration `yaml:"cooldown"`
+	FlagThreshold int           `yaml:"flag_threshold"`
+}
+
+// PasswordConfig holds the argon2id cost parameters, memory is in KiB.
+type PasswordConfig struct {
+	Memory      uint32 `yaml:"memory"`
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port: 8080,
+		},
+		Mongo: MongoConfig{
+			URI:      "mongodb://localhost:27017",
+			Database: "restaurantdb_1",
+		},
+		JWT: JWTConfig{
+			Algorithm:  "HS512",
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+		},
+		NLP: NLPConfig{
+			Provider:         "auto",
+			URL:              "http://127.0.0.1:8000",
+			HealthInterval:   15 * time.Second,
+			Timeout:          5 * time.Second,
+			MaxTextLength:    5000,
+			BatchSize:        50,
+			BatchConcurrency: 4,
+			BatchTextTimeout: time.Second,
+			Retries:          2,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+			Workers:          2,
+			MaxAttempts:      5,
+			RetryBackoff:     2 * time.Second,
+			MaxRetryBackoff:  5 * time.Minute,
+		},
+		Rating: RatingConfig{
+			Policy:                "weighted",
+			UserWeight:            0.7,
+			DisagreementThreshold: 2,
+			Penalty:               0.5,
+			DecayHalfLife:         180 * 24 * time.Hour,
+			PriorWeight:           10,
+		},
+		Reviews: ReviewsConfig{
+			Mode:          "single",
+			Cooldown:      30 * 24 * time.Hour,
+			FlagThreshold: 3,
+		},
+		Password: PasswordConfig{
+			Memory:      64 * 1024,
+			Iterations:  3,
+			Parallelism: 2,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the optional YAML file at path
+// and then environment variables, which take precedence over the file.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %v", err)
+		}
+
+		err = yaml.Unmarshal(data, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse config file: %v", err)
+		}
+	}
+
+	err := applyEnv(&cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func applyEnv(cfg *Config) error {
+	setString := func(name string, target *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+
+	setDuration := func(name string, target *time.Duration) error {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		*target = parsed
+		return nil
+	}
+
+	setUint := func(name string, bits int, target func(uint64)) error {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseUint(value, 10, bits)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		target(parsed)
+		return nil
+	}
+
+	setFloat := func(name string, target *float64) error {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		*target = parsed
+		return nil
+	}
+
+	setString("MONGO_URI", &cfg.Mongo.URI)
+	setString("MONGO_DATABASE", &cfg.Mongo.Database)
+	setString("JWT_ALGORITHM", &cfg.JWT.Algorithm)
+	setString("JWT_SECRET", &cfg.JWT.Secret)
+	setString("JWT_KEYS_DIR", &cfg.JWT.KeysDir)
+	setString("JWT_ACTIVE_KEY_ID", &cfg.JWT.ActiveKeyID)
+	setString("NLP_PROVIDER", &cfg.NLP.Provider)
+	setString("NLP_URL", &cfg.NLP.URL)
+	setString("RATING_POLICY", &cfg.Rating.Policy)
+	setString("REVIEWS_MODE", &cfg.Reviews.Mode)
+
+	if value, ok := os.LookupEnv("PORT"); ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid PORT: %v", err)
+		}
+		cfg.Server.Port = port
+	}
+
+	if value, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		cfg.Server.TrustedProxies = nil
+		for _, proxy := range strings.Split(value, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				cfg.Server.TrustedProxies = append(cfg.Server.TrustedProxies, proxy)
+			}
+		}
+	}
+
+	err := setDuration("JWT_ACCESS_TTL", &cfg.JWT.AccessTTL)
+	if err != nil {
+		return err
+	}
+	err = setDuration("JWT_REFRESH_TTL", &cfg.JWT.RefreshTTL)
+	if err != nil {
+		return err
+	}
+	err = setDuration("NLP_TIMEOUT", &cfg.NLP.Timeout)
+	if err != nil {
+		return err
+	}
+	err = setDuration("NLP_HEALTH_INTERVAL", &cfg.NLP.HealthInterval)
+	if err != nil {
+		return err
+	}
+	err = setDuration("NLP_BATCH_TEXT_TIMEOUT", &cfg.NLP.BatchTextTimeout)
+	if err != nil {
+		return err
+	}
+	err = setDuration("NLP_BREAKER_COOLDOWN", &cfg.NLP.BreakerCooldown)
+	if err != nil {
+		return err
+	}
+	err = setDuration("NLP_RETRY_BACKOFF", &cfg.NLP.RetryBackoff)
+	if err != nil {
+		return err
+	}
+	err = setDuration("NLP_MAX_RETRY_BACKOFF", &cfg.NLP.MaxRetryBackoff)
+	if err != nil {
+		return err
+	}
+
+	if value, ok := os.LookupEnv("NLP_WORKERS"); ok {
+		workers, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_WORKERS: %v", err)
+		}
+		cfg.NLP.Workers = workers
+	}
+
+	if value, ok := os.LookupEnv("NLP_MAX_TEXT_LENGTH"); ok {
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_MAX_TEXT_LENGTH: %v", err)
+		}
+		cfg.NLP.MaxTextLength = length
+	}
+
+	if value, ok := os.LookupEnv("NLP_BATCH_SIZE"); ok {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_BATCH_SIZE: %v", err)
+		}
+		cfg.NLP.BatchSize = size
+	}
+
+	if value, ok := os.LookupEnv("NLP_BATCH_CONCURRENCY"); ok {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_BATCH_CONCURRENCY: %v", err)
+		}
+		cfg.NLP.BatchConcurrency = concurrency
+	}
+
+	if value, ok := os.LookupEnv("NLP_RETRIES"); ok {
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_RETRIES: %v", err)
+		}
+		cfg.NLP.Retries = retries
+	}
+
+	if value, ok := os.LookupEnv("NLP_BREAKER_THRESHOLD"); ok {
+		threshold, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_BREAKER_THRESHOLD: %v", err)
+		}
+		cfg.NLP.BreakerThreshold = threshold
+	}
+
+	if value, ok := os.LookupEnv("NLP_MAX_ATTEMPTS"); ok {
+		attempts, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NLP_MAX_ATTEMPTS: %v", err)
+		}
+		cfg.NLP.MaxAttempts = attempts
+	}
+
+	if value, ok := os.LookupEnv("REVIEWS_FLAG_THRESHOLD"); ok {
+		threshold, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid REVIEWS_FLAG_THRESHOLD: %v", err)
+		}
+		cfg.Reviews.FlagThreshold = threshold
+	}
+
+	err = setDuration("REVIEWS_COOLDOWN", &cfg.Reviews.Cooldown)
+	if err != nil {
+		return err
+	}
+	err = setDuration("RATING_DECAY_HALF_LIFE", &cfg.Rating.DecayHalfLife)
+	if err != nil {
+		return err
+	}
+	err = setFloat("RATING_PRIOR_WEIGHT", &cfg.Rating.PriorWeight)
+	if err != nil {
+		return err
+	}
+	err = setFloat("RATING_USER_WEIGHT", &cfg.Rating.UserWeight)
+	if err != nil {
+		return err
+	}
+	err = setFloat("RATING_DISAGREEMENT_THRESHOLD", &cfg.Rating.DisagreementThreshold)
+	if err != nil {
+		return err
+	}
+	err = setFloat("RATING_PENALTY", &cfg.Rating.Penalty)
+	if err != nil {
+		return err
+	}
+
+	err = setUint("ARGON2_MEMORY", 32, func(v uint64) { cfg.Password.Memory = uint32(v) })
+	if err != nil {
+		return err
+	}
+	err = setUint("ARGON2_ITERATIONS", 32, func(v uint64) { cfg.Password.Iterations = uint32(v) })
+	if err != nil {
+		return err
+	}
+	err = setUint("ARGON2_PARALLELISM", 8, func(v uint64) { cfg.Password.Parallelism = uint8(v) })
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cfg Config) Validate() error {
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		return fmt.Errorf("server port must be between 1 and 65535, got %d", cfg.Server.Port)
+	}
+	if cfg.Mongo.URI == "" {
+		return fmt.Errorf("mongo uri is required")
+	}
+	if cfg.Mongo.Database == "" {
+		return fmt.Errorf("mongo database is required")
+	}
+	switch cfg.JWT.Algorithm {
+	case "HS512":
+		if len(cfg.JWT.Secret) < 16 {
+			return fmt.Errorf("jwt secret must be at least 16 characters, set JWT_SECRET")
+		}
+	case "RS256", "EdDSA":
+		if cfg.JWT.KeysDir == "" || cfg.JWT.ActiveKeyID == "" {
+			return fmt.Errorf("jwt keys_dir and active_key_id are required for %s", cfg.JWT.Algorithm)
+		}
+	default:
+		return fmt.Errorf("jwt algorithm must be HS512, RS256 or EdDSA, got %q", cfg.JWT.Algorithm)
+	}
+	if cfg.JWT.AccessTTL <= 0 || cfg.JWT.RefreshTTL <= 0 {
+		return fmt.Errorf("jwt token lifetimes must be positive")
+	}
+	if cfg.JWT.AccessTTL >= cfg.JWT.RefreshTTL {
+		return fmt.Errorf("jwt access_ttl must be shorter than refresh_ttl")
+	}
+	switch cfg.NLP.Provider {
+	case "http", "auto":
+		if cfg.NLP.URL == "" {
+			return fmt.Errorf("nlp url is required for the %s provider", cfg.NLP.Provider)
+		}
+	case "lexicon":
+	default:
+		return fmt.Errorf("nlp provider must be http, lexicon or auto, got %q", cfg.NLP.Provider)
+	}
+	if cfg.NLP.Provider == "auto" && cfg.NLP.HealthInterval <= 0 {
+		return fmt.Errorf("nlp health_interval must be positive")
+	}
+	if cfg.NLP.Timeout <= 0 {
+		return fmt.Errorf("nlp timeout must be positive")
+	}
+	if cfg.NLP.MaxTextLength < 1 {
+		return fmt.Errorf("nlp max_text_length must be at least 1")
+	}
+	// The NLP service accepts at most 100 texts per batch
+	if cfg.NLP.BatchSize < 1 || cfg.NLP.BatchSize > 100 {
+		return fmt.Errorf("nlp batch_size must be between 1 and 100")
+	}
+	if cfg.NLP.BatchConcurrency < 1 {
+		return fmt.Errorf("nlp batch_concurrency must be at least 1")
+	}
+	if cfg.NLP.BatchTextTimeout < 0 {
+		return fmt.Errorf("nlp batch_text_timeout can't be negative")
+	}
+	if cfg.NLP.Retries < 0 {
+		return fmt.Errorf("nlp retries can't be negative")
+	}
+	if cfg.NLP.BreakerThreshold < 1 || cfg.NLP.BreakerCooldown <= 0 {
+		return fmt.Errorf("nlp breaker_threshold must be at least 1 and breaker_cooldown positive")
+	}
+	if cfg.NLP.Workers < 1 || cfg.NLP.MaxAttempts < 1 {
+		return fmt.Errorf("nlp workers and max_attempts must be at least 1")
+	}
+	if cfg.NLP.RetryBackoff <= 0 || cfg.NLP.MaxRetryBackoff < cfg.NLP.RetryBackoff {
+		return fmt.Errorf("nlp retry_backoff must be positive and not above max_retry_backoff")
+	}
+	switch cfg.Rating.Policy {
+	case "weighted", "user", "nlp", "penalised":
+	default:
+		return fmt.Errorf("rating policy must be weighted, user, nlp or penalised, got %q", cfg.Rating.Policy)
+	}
+	if cfg.Rating.UserWeight < 0 || cfg.Rating.UserWeight > 1 {
+		return fmt.Errorf("rating user_weight must be between 0 and 1")
+	}
+	if cfg.Rating.DisagreementThreshold < 0 || cfg.Rating.Penalty < 0 {
+		return fmt.Errorf("rating disagreement_threshold and penalty can't be negative")
+	}
+	if cfg.Rating.DecayHalfLife < time.Hour {
+		return fmt.Errorf("rating decay_half_life must be at least an hour")
+	}
+	if cfg.Rating.PriorWeight < 0 {
+		return fmt.Errorf("rating prior_weight can't be negative")
+	}
+	if cfg.Reviews.Mode != "single" && cfg.Reviews.Mode != "per_visit" {
+		return fmt.Errorf("reviews mode must be single or per_visit, got %q", cfg.Reviews.Mode)
+	}
+	if cfg.Reviews.Cooldown < 0 {
+		return fmt.Errorf("reviews cooldown can't be negative")
+	}
+	if cfg.Reviews.FlagThreshold < 1 {
+		return fmt.Errorf("reviews flag_threshold must be at least 1")
+	}
+	if cfg.Password.Iterations < 1 || cfg.Password.Parallelism < 1 {
+		return fmt.Errorf("password iterations and parallelism must be at least 1")
+	}
+	if cfg.Password.Memory < 8*uint32(cfg.Password.Parallelism) {
+		return fmt.Errorf("password memory must be at least 8 KiB per thread")
+	}
+
+	return nil
+}
+
+func (s ServerConfig) Addr() string {
+	return fmt.Sprintf(":%d", s.Port)
+}
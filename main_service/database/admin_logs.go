@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AdminLogFilter selects admin log entries. Empty fields and zero times don't
+// filter; From is inclusive and To exclusive.
+type AdminLogFilter struct {
+	AdminID    string
+	Action     string
+	ActionType string
+	Target     string
+	From       time.Time
+	To         time.Time
+}
+
+func (f AdminLogFilter) query() bson.M {
+	query := bson.M{}
+	if f.AdminID != "" {
+		query["adminId"] = f.AdminID
+	}
+	if f.Action != "" {
+		query["action"] = f.Action
+	}
+	if f.ActionType != "" {
+		query["actionType"] = f.ActionType
+	}
+	if f.Target != "" {
+		query["target"] = f.Target
+	}
+
+	createdAt := bson.M{}
+	if !f.From.IsZero() {
+		createdAt["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		createdAt["$lt"] = f.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	return query
+}
+
+func CreateAdminLog(entry internal.AdminLog) error {
+	collection := getCollection("admins_logs")
+
+	_, err := collection.InsertOne(Cxt, entry)
+	if err != nil {
+		return fmt.Errorf("failed to write admin log: %s", err)
+	}
+
+	return nil
+}
+
+// GetAdminLogs returns one page of the matching entries, newest first, and the
+// number of matching entries.
+func GetAdminLogs(filter AdminLogFilter, page int64, limit int64) ([]internal.AdminLog, int64, error) {
+	collection := getCollection("admins_logs")
+	query := filter.query()
+
+	total, err := collection.CountDocuments(Cxt, query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count admin logs: %s", err)
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(Cxt, query, findOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list admin logs: %s", err)
+	}
+
+	entries := []internal.AdminLog{}
+	if err := cursor.All(Cxt, &entries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode admin logs: %s", err)
+	}
+
+	return entries, total, nil
+}
+
+// EachAdminLog calls fn with every matching entry, newest first, without loading
+// them all at once. It stops at the first error fn returns.
+func EachAdminLog(filter AdminLogFilter, fn func(internal.AdminLog) error) error {
+	collection := getCollection("admins_logs")
+
+	cursor, err := collection.Find(Cxt, filter.query(), options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return fmt.Errorf("failed to list admin logs: %s", err)
+	}
+	defer cursor.Close(Cxt)
+
+	for cursor.Next(Cxt) {
+		var entry internal.AdminLog
+		if err := cursor.Decode(&entry); err != nil {
+			return fmt.Errorf("failed to decode admin log: %s", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ratingLookupStages joins a restaurant with its document in the ratings collection.
+// Restaurants without reviews keep an empty rating.
+func ratingLookupStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "ratings"},
+			{Key: "localField", Value: "_id"},
+			{Key: "foreignField", Value: "restaurantId"},
+			{Key: "as", Value: "rating"},
+		}}},
+		{{Key: "$unwind", Value: bson.D{
+			{Key: "path", Value: "$rating"},
+			{Key: "preserveNullAndEmptyArrays", Value: true},
+		}}},
+	}
+}
+
+func CreateCategory(category internal.Category) (internal.Category, error) {
+	collection := getCollection("categories")
+
+	_, err := collection.InsertOne(Cxt, category)
+	if err != nil {
+		return category, err
+	}
+
+	return category, nil
+}
+
+func GetCategory(id string) (internal.Category, error) {
+	collection := getCollection("categories")
+
+	var category internal.Category
+	err := collection.FindOne(Cxt, bson.M{"_id": id}).Decode(&category)
+	if err != nil {
+		return category, err
+	}
+
+	return category, nil
+}
+
+func GetCategories() ([]internal.Category, error) {
+	collection := getCollection("categories")
+
+	cursor, err := collection.Find(Cxt, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %s", err)
+	}
+
+	categories := []internal.Category{}
+	if err := cursor.All(Cxt, &categories); err != nil {
+		return nil, fmt.Errorf("failed to decode categories: %s", err)
+	}
+
+	return categories, nil
+}
+
+func RenameCategory(id string, name string) error {
+	collection := getCollection("categories")
+
+	result, err := collection.UpdateOne(Cxt, bson.M{"_id": id}, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}}}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func CountCategoryRestaurants(id string) (int64, error) {
+	collection := getCollection("restaurants")
+
+	count, err := collection.CountDocuments(Cxt, bson.M{"categoryId": id})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count restaurants: %s", err)
+	}
+
+	return count, nil
+}
+
+func ReassignRestaurants(fromID string, toID string) (int64, error) {
+	collection := getCollection("restaurants")
+
+	result, err := collection.UpdateMany(
+		Cxt,
+		bson.M{"categoryId": fromID},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "categoryId", Value: toID}}}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reassign restaurants: %s", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// uncategorizeRestaurants takes the restaurants out of the category.
+func uncategorizeRestaurants(id string) (int64, error) {
+	collection := getCollection("restaurants")
+
+	result, err := collection.UpdateMany(
+		Cxt,
+		bson.M{"categoryId": id},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "categoryId", Value: ""}}}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to uncategorize restaurants: %s", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// DeleteCategory removes the category and moves its restaurants into the reassignTo
+// category, or leaves them without a category when reassignTo is empty. They are
+// moved after the category is gone, so a restaurant added to it while it was being
+// deleted doesn't keep pointing at it. It returns how many restaurants were moved.
+func DeleteCategory(id string, reassignTo string) (int64, error) {
+	collection := getCollection("categories")
+
+	result, err := collection.DeleteOne(Cxt, bson.M{"_id": id})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete category: %s", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return 0, mongo.ErrNoDocuments
+	}
+
+	if reassignTo == "" {
+		return uncategorizeRestaurants(id)
+	}
+
+	return ReassignRestaurants(id, reassignTo)
+}
+
+// MergeCategories moves every restaurant of the source category into the target
+// one and removes the source category.
+func MergeCategories(sourceID string, targetID string) (int64, error) {
+	return DeleteCategory(sourceID, targetID)
+}
+
+func GetCategoryRestaurants(id string) ([]internal.RestaurantWithRating, error) {
+	collection := getCollection("restaurants")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "categoryId", Value: id}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}}}},
+	}
+	pipeline = append(pipeline, ratingLookupStages()...)
+
+	cursor, err := collection.Aggregate(Cxt, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list category restaurants: %s", err)
+	}
+
+	restaurants := []internal.RestaurantWithRating{}
+	if err := cursor.All(Cxt, &restaurants); err != nil {
+		return nil, fmt.Errorf("failed to decode restaurants: %s", err)
+	}
+
+	return restaurants, nil
+}
@@ -0,0 +1,300 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxCollectionEntries is how many restaurants a collection can hold.
+const MaxCollectionEntries = 200
+
+// setEntryAttempts is how often SetCollectionEntry starts over when the collection
+// changed between reading and updating it.
+const setEntryAttempts = 5
+
+var (
+	// ErrCollectionExists is returned when the user already has a collection with
+	// the name.
+	ErrCollectionExists = errors.New("collection already exists")
+	// ErrCollectionFull is returned when adding to a collection at MaxCollectionEntries.
+	ErrCollectionFull = errors.New("collection is full")
+	// ErrCollectionChanged is returned when a collection was changed while it was
+	// being reordered, or kept changing while a restaurant was added to it.
+	ErrCollectionChanged = errors.New("collection changed")
+)
+
+func CreateCollection(collection internal.Collection) (internal.Collection, error) {
+	// Entries are pushed to, so they have to be stored as an array
+	if collection.Entries == nil {
+		collection.Entries = []internal.CollectionEntry{}
+	}
+
+	_, err := getCollection("collections").InsertOne(Cxt, collection)
+	if mongo.IsDuplicateKeyError(err) {
+		return collection, ErrCollectionExists
+	}
+	if err != nil {
+		return collection, fmt.Errorf("failed to create collection: %s", err)
+	}
+
+	return collection, nil
+}
+
+func GetCollection(id string) (internal.Collection, error) {
+	var collection internal.Collection
+	err := getCollection("collections").FindOne(Cxt, bson.M{"_id": id}).Decode(&collection)
+	if err != nil {
+		return collection, err
+	}
+
+	return collection, nil
+}
+
+// GetSharedCollection returns the collection with the share token unless it is
+// private.
+func GetSharedCollection(token string) (internal.Collection, error) {
+	var collection internal.Collection
+	err := getCollection("collections").FindOne(
+		Cxt,
+		bson.M{"shareToken": token, "visibility": bson.M{"$ne": internal.CollectionPrivate}},
+	).Decode(&collection)
+	if err != nil {
+		return collection, err
+	}
+
+	return collection, nil
+}
+
+// GetUserCollections returns the user's collections by name.
+func GetUserCollections(userID string) ([]internal.Collection, error) {
+	cursor, err := getCollection("collections").Find(
+		Cxt,
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collections: %s", err)
+	}
+
+	collections := []internal.Collection{}
+	if err := cursor.All(Cxt, &collections); err != nil {
+		return nil, fmt.Errorf("failed to decode collections: %s", err)
+	}
+
+	return collections, nil
+}
+
+// GetPublicCollections returns one page of public collections, most recently
+// updated first, and the number of public collections.
+func GetPublicCollections(page int64, limit int64) ([]internal.Collection, int64, error) {
+	collection := getCollection("collections")
+	filter := bson.M{"visibility": internal.CollectionPublic}
+
+	total, err := collection.CountDocuments(Cxt, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count collections: %s", err)
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(Cxt, filter, findOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list collections: %s", err)
+	}
+
+	collections := []internal.Collection{}
+	if err := cursor.All(Cxt, &collections); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode collections: %s", err)
+	}
+
+	return collections, total, nil
+}
+
+// collectionChanged is added to every update of a collection. The revision tells
+// the version a change was based on from a later one.
+var collectionChanged = bson.D{
+	{Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}},
+	{Key: "$currentDate", Value: bson.D{{Key: "updatedAt", Value: true}}},
+}
+
+// updateUserCollection applies update to the user's collection and returns it as
+// it is afterwards, or mongo.ErrNoDocuments when the user has no such collection.
+func updateUserCollection(id string, userID string, update bson.D) (internal.Collection, error) {
+	update = append(update, collectionChanged...)
+
+	var collection internal.Collection
+	err := getCollection("collections").FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": id, "userId": userID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&collection)
+	if mongo.IsDuplicateKeyError(err) {
+		return collection, ErrCollectionExists
+	}
+
+	return collection, err
+}
+
+func UpdateCollection(id string, userID string, name string, visibility string) (internal.Collection, error) {
+	return updateUserCollection(id, userID, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: name},
+		{Key: "visibility", Value: visibility},
+	}}})
+}
+
+// SetCollectionShareToken replaces the share token, so links with the old one stop
+// working.
+func SetCollectionShareToken(id string, userID string, token string) (internal.Collection, error) {
+	return updateUserCollection(id, userID, bson.D{{Key: "$set", Value: bson.D{{Key: "shareToken", Value: token}}}})
+}
+
+func DeleteCollection(id string, userID string) error {
+	result, err := getCollection("collections").DeleteOne(Cxt, bson.M{"_id": id, "userId": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete collection: %s", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// SetCollectionEntry adds the restaurant to the end of the user's collection with
+// the note, or only replaces the note when it is already there. The push only
+// matches while the collection has room, so concurrent adds can't overfill it.
+// When the collection keeps changing in between it gives up with
+// ErrCollectionChanged.
+func SetCollectionEntry(id string, userID string, restaurantID string, note string) (internal.Collection, error) {
+	collections := getCollection("collections")
+
+	var collection internal.Collection
+	for attempt := 0; attempt < setEntryAttempts; attempt++ {
+		err := collections.FindOneAndUpdate(
+			Cxt,
+			bson.M{"_id": id, "userId": userID, "entries.restaurantId": restaurantID},
+			append(bson.D{{Key: "$set", Value: bson.D{{Key: "entries.$.note", Value: note}}}}, collectionChanged...),
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&collection)
+		if err != mongo.ErrNoDocuments {
+			return collection, err
+		}
+
+		collection, err = GetCollection(id)
+		if err != nil {
+			return collection, err
+		}
+		if collection.UserID != userID {
+			return collection, mongo.ErrNoDocuments
+		}
+		if len(collection.Entries) >= MaxCollectionEntries {
+			return collection, ErrCollectionFull
+		}
+
+		err = collections.FindOneAndUpdate(
+			Cxt,
+			bson.M{
+				"_id":                  id,
+				"userId":               userID,
+				"entries.restaurantId": bson.M{"$ne": restaurantID},
+				fmt.Sprintf("entries.%d", MaxCollectionEntries-1): bson.M{"$exists": false},
+			},
+			append(bson.D{{Key: "$push", Value: bson.D{{Key: "entries", Value: internal.CollectionEntry{
+				RestaurantID: restaurantID,
+				Note:         note,
+				AddedAt:      time.Now().UTC(),
+			}}}}}, collectionChanged...),
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&collection)
+		// Changed since it was read, start over
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return collection, fmt.Errorf("failed to add to collection: %s", err)
+		}
+
+		return collection, nil
+	}
+
+	return collection, ErrCollectionChanged
+}
+
+func RemoveCollectionEntry(id string, userID string, restaurantID string) (internal.Collection, error) {
+	return updateUserCollection(id, userID, bson.D{{Key: "$pull", Value: bson.D{
+		{Key: "entries", Value: bson.D{{Key: "restaurantId", Value: restaurantID}}},
+	}}})
+}
+
+// ReorderCollection stores the entries of a collection in a new order. It fails
+// with ErrCollectionChanged when the collection isn't as it was when read.
+func ReorderCollection(collection internal.Collection, entries []internal.CollectionEntry) (internal.Collection, error) {
+	var updated internal.Collection
+	err := getCollection("collections").FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": collection.ID, "userId": collection.UserID, "revision": collection.Revision},
+		append(bson.D{{Key: "$set", Value: bson.D{{Key: "entries", Value: entries}}}}, collectionChanged...),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return updated, ErrCollectionChanged
+	}
+	if err != nil {
+		return updated, fmt.Errorf("failed to reorder collection: %s", err)
+	}
+
+	return updated, nil
+}
+
+// GetCollectionRestaurants returns the restaurants of the collection with their
+// ratings, in the collection's order. Restaurants that no longer exist are left out.
+func GetCollectionRestaurants(collection internal.Collection) ([]internal.CollectionRestaurant, error) {
+	ids := make([]string, len(collection.Entries))
+	for i, entry := range collection.Entries {
+		ids[i] = entry.RestaurantID
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}}}}
+	pipeline = append(pipeline, ratingLookupStages()...)
+
+	cursor, err := getCollection("restaurants").Aggregate(Cxt, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collection restaurants: %s", err)
+	}
+
+	var found []internal.RestaurantWithRating
+	if err := cursor.All(Cxt, &found); err != nil {
+		return nil, fmt.Errorf("failed to decode collection restaurants: %s", err)
+	}
+
+	byID := map[string]internal.RestaurantWithRating{}
+	for _, restaurant := range found {
+		byID[restaurant.ID] = restaurant
+	}
+
+	restaurants := []internal.CollectionRestaurant{}
+	for _, entry := range collection.Entries {
+		restaurant, ok := byID[entry.RestaurantID]
+		if !ok {
+			continue
+		}
+		restaurants = append(restaurants, internal.CollectionRestaurant{
+			RestaurantWithRating: restaurant,
+			Note:                 entry.Note,
+			AddedAt:              entry.AddedAt,
+		})
+	}
+
+	return restaurants, nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCollectionEntries(t *testing.T) {
+	connectTestDB(t)
+
+	entries := make([]internal.CollectionEntry, MaxCollectionEntries-5)
+	for i := range entries {
+		entries[i] = internal.CollectionEntry{RestaurantID: fmt.Sprintf("restaurant-%d", i), AddedAt: time.Now().UTC()}
+	}
+	collection, err := CreateCollection(internal.Collection{
+		ID:         "collection",
+		UserID:     "user",
+		Name:       "test",
+		Visibility: internal.CollectionPrivate,
+		ShareToken: "token",
+		Entries:    entries,
+		CreatedAt:  time.Now().UTC(),
+		UpdatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only five of the twenty restaurants fit, the others must find it full
+	runParallel(t, 20, func(i int) error {
+		_, err := SetCollectionEntry(collection.ID, collection.UserID, fmt.Sprintf("added-%d", i), "")
+		if err == ErrCollectionFull || err == ErrCollectionChanged {
+			return nil
+		}
+		return err
+	})
+
+	collection, err = GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collection.Entries) != MaxCollectionEntries {
+		t.Errorf("collection holds %d restaurants, want %d", len(collection.Entries), MaxCollectionEntries)
+	}
+	if collection.Revision != 5 {
+		t.Errorf("revision = %d, want 5", collection.Revision)
+	}
+
+	// A reorder based on an older revision is refused
+	stale := collection
+	stale.Revision--
+	_, err = ReorderCollection(stale, collection.Entries)
+	if err != ErrCollectionChanged {
+		t.Errorf("stale reorder: err = %v, want ErrCollectionChanged", err)
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AddFavorite adds the restaurant to the user's favorites. It returns false when it
+// was already there.
+func AddFavorite(userID string, restaurantID string) (bool, error) {
+	collection := getCollection("favorites")
+
+	result, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"userId": userID, "restaurantId": restaurantID},
+		bson.D{{Key: "$setOnInsert", Value: bson.D{
+			{Key: "_id", Value: primitive.NewObjectID().Hex()},
+			{Key: "addedAt", Value: time.Now().UTC()},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	// A concurrent request added it first
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to add favorite: %s", err)
+	}
+
+	return result.UpsertedCount > 0, nil
+}
+
+// RemoveFavorite removes the restaurant from the user's favorites, if it is there.
+func RemoveFavorite(userID string, restaurantID string) error {
+	collection := getCollection("favorites")
+
+	_, err := collection.DeleteOne(Cxt, bson.M{"userId": userID, "restaurantId": restaurantID})
+	if err != nil {
+		return fmt.Errorf("failed to remove favorite: %s", err)
+	}
+
+	return nil
+}
+
+// GetFavorites returns the user's favorite restaurants with their ratings, most
+// recently added first.
+func GetFavorites(userID string) ([]internal.FavoriteRestaurant, error) {
+	collection := getCollection("favorites")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "userId", Value: userID}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "addedAt", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "restaurants"},
+			{Key: "localField", Value: "restaurantId"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "restaurant"},
+		}}},
+		{{Key: "$unwind", Value: "$restaurant"}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: bson.D{
+			{Key: "$mergeObjects", Value: bson.A{"$restaurant", bson.D{{Key: "addedAt", Value: "$addedAt"}}}},
+		}}}}},
+	}
+	pipeline = append(pipeline, ratingLookupStages()...)
+
+	cursor, err := collection.Aggregate(Cxt, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list favorites: %s", err)
+	}
+
+	favorites := []internal.FavoriteRestaurant{}
+	if err := cursor.All(Cxt, &favorites); err != nil {
+		return nil, fmt.Errorf("failed to decode favorites: %s", err)
+	}
+
+	return favorites, nil
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jobQueued     = "queued"
+	jobProcessing = "processing"
+)
+
+// EnqueueNLPJob schedules scoring of the given review revision. A review has at most
+// one job, so queueing a newer revision replaces the pending one.
+func EnqueueNLPJob(reviewID string, revision int, userRating float64) error {
+	collection := getCollection("nlp_jobs")
+	now := time.Now().UTC()
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"reviewId": reviewID},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "revision", Value: revision},
+				{Key: "userRating", Value: userRating},
+				{Key: "status", Value: jobQueued},
+				{Key: "attempts", Value: 0},
+				{Key: "runAt", Value: now},
+				{Key: "createdAt", Value: now},
+			}},
+			{Key: "$unset", Value: bson.D{
+				{Key: "lockedUntil", Value: ""},
+				{Key: "lastError", Value: ""},
+			}},
+			{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: primitive.NewObjectID().Hex()}}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue nlp job: %s", err)
+	}
+
+	return nil
+}
+
+// ClaimNLPJob locks the next due job for lease and counts the attempt. Jobs whose
+// lease ran out, because their worker died, are picked up again.
+func ClaimNLPJob(lease time.Duration) (internal.NLPJob, error) {
+	collection := getCollection("nlp_jobs")
+	now := time.Now().UTC()
+
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{
+			{Key: "status", Value: jobQueued},
+			{Key: "runAt", Value: bson.D{{Key: "$lte", Value: now}}},
+		},
+		bson.D{
+			{Key: "status", Value: jobProcessing},
+			{Key: "lockedUntil", Value: bson.D{{Key: "$lte", Value: now}}},
+		},
+	}}}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: jobProcessing},
+			{Key: "lockedUntil", Value: now.Add(lease)},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+	}
+
+	var job internal.NLPJob
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		filter,
+		update,
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "runAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&job)
+
+	return job, err
+}
+
+// CompleteNLPJob removes the job unless it was replaced by a newer revision meanwhile.
+func CompleteNLPJob(job internal.NLPJob) error {
+	collection := getCollection("nlp_jobs")
+
+	_, err := collection.DeleteOne(Cxt, bson.M{"_id": job.ID, "revision": job.Revision})
+	if err != nil {
+		return fmt.Errorf("failed to complete nlp job: %s", err)
+	}
+
+	return nil
+}
+
+func RetryNLPJob(job internal.NLPJob, runAt time.Time, lastError string) error {
+	collection := getCollection("nlp_jobs")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": job.ID, "revision": job.Revision},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: jobQueued},
+				{Key: "runAt", Value: runAt},
+				{Key: "lastError", Value: lastError},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "lockedUntil", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule nlp job: %s", err)
+	}
+
+	return nil
+}
+
+// PostponeNLPJob puts the job back in the queue without counting the attempt.
+func PostponeNLPJob(job internal.NLPJob, runAt time.Time) error {
+	collection := getCollection("nlp_jobs")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": job.ID, "revision": job.Revision},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: jobQueued},
+				{Key: "runAt", Value: runAt},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "attempts", Value: -1}}},
+			{Key: "$unset", Value: bson.D{{Key: "lockedUntil", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to postpone nlp job: %s", err)
+	}
+
+	return nil
+}
+
+// DeadLetterNLPJob moves a job that ran out of attempts to nlp_jobs_dead and marks
+// its review revision as failed.
+func DeadLetterNLPJob(job internal.NLPJob, lastError string) error {
+	now := time.Now().UTC()
+	dead := job
+	dead.ID = primitive.NewObjectID().Hex()
+	dead.LastError = lastError
+	dead.FailedAt = &now
+	dead.LockedUntil = nil
+
+	_, err := getCollection("nlp_jobs_dead").InsertOne(Cxt, dead)
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter nlp job: %s", err)
+	}
+
+	err = FailReviewAnalysis(job.ReviewID, job.Revision)
+	if err != nil {
+		return err
+	}
+
+	return CompleteNLPJob(job)
+}
+
+func DeleteNLPJob(reviewID string) error {
+	collection := getCollection("nlp_jobs")
+
+	_, err := collection.DeleteOne(Cxt, bson.M{"reviewId": reviewID})
+	if err != nil {
+		return fmt.Errorf("failed to delete nlp job: %s", err)
+	}
+
+	return nil
+}
@@ -214,10 +214,460 @@ var migrations = []struct {
 			return err
 		},
 	},
+	{
+		Version: 9,
+		Name:    "Store restaurant locations as GeoJSON points",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection("restaurants")
+
+			// The 2dsphere index from migration 3 covers two scalar fields and
+			// can't serve $near queries, so it is replaced by one on "location".
+			_, err := collection.Indexes().DropOne(ctx, "location.latitude_2dsphere_location.longitude_2dsphere")
+			if err != nil {
+				if cmdErr, ok := err.(mongo.CommandError); !ok || cmdErr.Code != 27 {
+					return err
+				}
+			}
+
+			_, err = collection.UpdateMany(ctx,
+				bson.D{{Key: "location.latitude", Value: bson.D{{Key: "$exists", Value: true}}}},
+				mongo.Pipeline{
+					{{Key: "$set", Value: bson.D{{Key: "location", Value: bson.D{
+						{Key: "type", Value: "Point"},
+						{Key: "coordinates", Value: bson.A{"$location.longitude", "$location.latitude"}},
+						{Key: "address", Value: "$location.address"},
+					}}}}},
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "location", Value: "2dsphere"}},
+			})
+			return err
+		},
+	},
+	{
+		Version: 10,
+		Name:    "Create refresh_tokens collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			err := db.CreateCollection(ctx, "refresh_tokens")
+			if err != nil {
+				if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == 48 {
+					return nil
+				}
+				return err
+			}
+
+			_, err = db.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+					Options: options.Index().SetUnique(true),
+				},
+				{
+					Keys: bson.D{{Key: "familyId", Value: 1}},
+				},
+				{
+					Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+	},
+	{
+		Version: 11,
+		Name:    "Create revoked_tokens collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			err := db.CreateCollection(ctx, "revoked_tokens")
+			if err != nil {
+				if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == 48 {
+					return nil
+				}
+				return err
+			}
+
+			_, err = db.Collection("revoked_tokens").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+	},
+	{
+		Version: 12,
+		Name:    "Create nlp_jobs and nlp_jobs_dead collections",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Reviews written before scoring moved to the background were
+			// scored on the spot and already count towards the ratings.
+			_, err := db.Collection("reviews").UpdateMany(ctx,
+				bson.D{{Key: "status", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "status", Value: "published"},
+					{Key: "revision", Value: 1},
+				}}},
+			)
+			if err != nil {
+				return err
+			}
+
+			err = db.CreateCollection(ctx, "nlp_jobs")
+			if err != nil {
+				if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == 48 {
+					return nil
+				}
+				return err
+			}
+
+			_, err = db.Collection("nlp_jobs").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "reviewId", Value: 1}},
+					Options: options.Index().SetUnique(true),
+				},
+				{
+					Keys: bson.D{
+						{Key: "status", Value: 1},
+						{Key: "runAt", Value: 1},
+					},
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			err = db.CreateCollection(ctx, "nlp_jobs_dead")
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("nlp_jobs_dead").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "reviewId", Value: 1}},
+			})
+			return err
+		},
+	},
+	{
+		Version: 13,
+		Name:    "Index reviews by status",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("reviews").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "restaurantId", Value: 1},
+					{Key: "status", Value: 1},
+					{Key: "createdAt", Value: -1},
+				},
+			})
+			return err
+		},
+	},
+	{
+		Version: 14,
+		Name:    "Backfill review user ratings and create rescore_jobs collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			reviews := db.Collection("reviews")
+
+			// Unpublished reviews still hold the user's stars in rating
+			_, err := reviews.UpdateMany(ctx,
+				bson.M{"userRating": bson.M{"$exists": false}, "status": bson.M{"$ne": "published"}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "userRating", Value: "$rating"}}}}},
+			)
+			if err != nil {
+				return err
+			}
+
+			// Published ones hold the 70/30 blend, undo it where the NLP rating is known
+			cursor, err := reviews.Aggregate(ctx, mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{
+					{Key: "status", Value: "published"},
+					{Key: "userRating", Value: bson.D{{Key: "$exists", Value: false}}},
+				}}},
+				{{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "nlp_results"},
+					{Key: "localField", Value: "_id"},
+					{Key: "foreignField", Value: "reviewId"},
+					{Key: "as", Value: "nlp"},
+				}}},
+				{{Key: "$project", Value: bson.D{
+					{Key: "userRating", Value: bson.D{{Key: "$cond", Value: bson.A{
+						bson.D{{Key: "$gt", Value: bson.A{bson.D{{Key: "$size", Value: "$nlp"}}, 0}}},
+						bson.D{{Key: "$max", Value: bson.A{0, bson.D{{Key: "$min", Value: bson.A{5,
+							bson.D{{Key: "$divide", Value: bson.A{
+								bson.D{{Key: "$subtract", Value: bson.A{
+									"$rating",
+									bson.D{{Key: "$multiply", Value: bson.A{0.3, bson.D{{Key: "$first", Value: "$nlp.rating"}}}}},
+								}}},
+								0.7,
+							}}},
+						}}}}}},
+						"$rating",
+					}}}},
+				}}},
+				{{Key: "$merge", Value: bson.D{
+					{Key: "into", Value: "reviews"},
+					{Key: "on", Value: "_id"},
+					{Key: "whenMatched", Value: "merge"},
+					{Key: "whenNotMatched", Value: "discard"},
+				}}},
+			})
+			if err != nil {
+				return err
+			}
+			cursor.Close(ctx)
+
+			err = db.CreateCollection(ctx, "rescore_jobs")
+			if err != nil {
+				if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == 48 {
+					return nil
+				}
+				return err
+			}
+
+			// Only one job may be running at a time
+			_, err = db.Collection("rescore_jobs").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "status", Value: 1}},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.D{{Key: "status", Value: "running"}}),
+			})
+			return err
+		},
+	},
+	{
+		Version: 15,
+		Name:    "Backfill review NLP ratings and rating policy",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// Every review published so far was rated with the fixed 70/30 mix
+			cursor, err := db.Collection("reviews").Aggregate(ctx, mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{
+					{Key: "status", Value: "published"},
+					{Key: "policyVersion", Value: bson.D{{Key: "$exists", Value: false}}},
+				}}},
+				{{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "nlp_results"},
+					{Key: "localField", Value: "_id"},
+					{Key: "foreignField", Value: "reviewId"},
+					{Key: "as", Value: "nlp"},
+				}}},
+				{{Key: "$project", Value: bson.D{
+					{Key: "policyVersion", Value: "weighted:0.7"},
+					{Key: "nlpRating", Value: bson.D{{Key: "$first", Value: "$nlp.rating"}}},
+				}}},
+				{{Key: "$merge", Value: bson.D{
+					{Key: "into", Value: "reviews"},
+					{Key: "on", Value: "_id"},
+					{Key: "whenMatched", Value: "merge"},
+					{Key: "whenNotMatched", Value: "discard"},
+				}}},
+			})
+			if err != nil {
+				return err
+			}
+			return cursor.Close(ctx)
+		},
+	},
+	{
+		Version: 16,
+		Name:    "Number review visits and make them unique per user and restaurant",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			reviews := db.Collection("reviews")
+
+			missing, err := reviews.CountDocuments(ctx, bson.M{"visit": bson.M{"$exists": false}})
+			if err != nil {
+				return err
+			}
+
+			if missing > 0 {
+				cursor, err := reviews.Aggregate(ctx, mongo.Pipeline{
+					{{Key: "$group", Value: bson.D{
+						{Key: "_id", Value: bson.D{
+							{Key: "userId", Value: "$userId"},
+							{Key: "restaurantId", Value: "$restaurantId"},
+						}},
+						{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+					}}},
+					{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+					{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}}}},
+				})
+				if err != nil {
+					return err
+				}
+
+				var duplicates []struct {
+					ID struct {
+						UserID       string `bson:"userId"`
+						RestaurantID string `bson:"restaurantId"`
+					} `bson:"_id"`
+					Count int `bson:"count"`
+				}
+				err = cursor.All(ctx, &duplicates)
+				if err != nil {
+					return err
+				}
+
+				// Duplicates are kept as separate visits, listed here so they can be reviewed
+				if len(duplicates) > 0 {
+					log.Printf("Found %d user and restaurant pairs with more than one review\n", len(duplicates))
+					for i, duplicate := range duplicates {
+						if i == 20 {
+							log.Printf("... and %d more\n", len(duplicates)-i)
+							break
+						}
+						log.Printf("user %s, restaurant %s: %d reviews\n", duplicate.ID.UserID, duplicate.ID.RestaurantID, duplicate.Count)
+					}
+				}
+
+				cursor, err = reviews.Aggregate(ctx, mongo.Pipeline{
+					{{Key: "$setWindowFields", Value: bson.D{
+						{Key: "partitionBy", Value: bson.D{
+							{Key: "userId", Value: "$userId"},
+							{Key: "restaurantId", Value: "$restaurantId"},
+						}},
+						{Key: "sortBy", Value: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+						{Key: "output", Value: bson.D{
+							{Key: "visit", Value: bson.D{{Key: "$documentNumber", Value: bson.D{}}}},
+						}},
+					}}},
+					{{Key: "$project", Value: bson.D{{Key: "visit", Value: 1}}}},
+					{{Key: "$merge", Value: bson.D{
+						{Key: "into", Value: "reviews"},
+						{Key: "on", Value: "_id"},
+						{Key: "whenMatched", Value: "merge"},
+						{Key: "whenNotMatched", Value: "discard"},
+					}}},
+				})
+				if err != nil {
+					return err
+				}
+				err = cursor.Close(ctx)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = reviews.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "userId", Value: 1},
+					{Key: "restaurantId", Value: 1},
+					{Key: "visit", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			})
+			return err
+		},
+	},
+	{
+		Version: 17,
+		Name:    "Create review_flags collection and moderation index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("review_flags").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "reviewId", Value: 1},
+					{Key: "userId", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("reviews").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys: bson.D{{Key: "status", Value: 1}},
+				},
+				{
+					Keys:    bson.D{{Key: "flags", Value: -1}},
+					Options: options.Index().SetSparse(true),
+				},
+			})
+			return err
+		},
+	},
+	{
+		Version: 18,
+		Name:    "Index admins_logs by target and request",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("admins_logs").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys: bson.D{{Key: "target", Value: 1}},
+				},
+				{
+					Keys: bson.D{{Key: "requestId", Value: 1}},
+				},
+			})
+			return err
+		},
+	},
+	{
+		Version: 19,
+		Name:    "Create collections collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("collections").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys: bson.D{
+						{Key: "userId", Value: 1},
+						{Key: "name", Value: 1},
+					},
+					Options: options.Index().SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "shareToken", Value: 1}},
+					Options: options.Index().SetUnique(true),
+				},
+				{
+					Keys: bson.D{
+						{Key: "visibility", Value: 1},
+						{Key: "updatedAt", Value: -1},
+					},
+				},
+				{
+					Keys: bson.D{{Key: "entries.restaurantId", Value: 1}},
+				},
+			})
+			return err
+		},
+	},
+	{
+		Version: 20,
+		Name:    "Mark admins_logs entries as reads or writes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			logs := db.Collection("admins_logs")
+
+			// Entries from before methods were logged were all writes
+			_, err := logs.UpdateMany(ctx,
+				bson.M{"action": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "action", Value: bson.D{{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$in", Value: bson.A{"$method", bson.A{"GET", "HEAD", "OPTIONS"}}}},
+					"read",
+					"write",
+				}}}}}}}},
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = logs.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "action", Value: 1}, {Key: "createdAt", Value: -1}},
+			})
+			return err
+		},
+	},
+	{
+		Version: 21,
+		Name:    "Add revision to collections",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("collections").UpdateMany(ctx,
+				bson.M{"revision": bson.M{"$exists": false}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "revision", Value: 0}}}},
+			)
+			return err
+		},
+	},
 }
 
 func RunMigrations(ctx context.Context) error {
-	db := MongoDB.Database("restaurantdb_1")
+	db := MongoDB.Database(databaseName)
 
 	for _, migration := range migrations {
 		log.Printf("Applying migration %d: %s\n", migration.Version, migration.Name)
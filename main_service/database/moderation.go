@@ -0,0 +1,243 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"restaurant_reviews/internal"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// flagThreshold is how many reports take a published review out of the ratings
+// until a moderator decides on it.
+var flagThreshold = 3
+
+func SetFlagThreshold(threshold int) {
+	flagThreshold = threshold
+}
+
+// ErrAlreadyFlagged is returned when the user already reported the review.
+var ErrAlreadyFlagged = errors.New("review already flagged")
+
+// FlagReview records a report of a published review and returns the review. Once
+// it has flagThreshold reports it is held for moderation and leaves the restaurant
+// aggregate.
+func FlagReview(flag internal.ReviewFlag) (internal.Review, error) {
+	var review internal.Review
+
+	flags := getCollection("review_flags")
+	_, err := flags.InsertOne(Cxt, flag)
+	if mongo.IsDuplicateKeyError(err) {
+		return review, ErrAlreadyFlagged
+	}
+	if err != nil {
+		return review, fmt.Errorf("failed to flag review: %s", err)
+	}
+
+	collection := getCollection("reviews")
+	err = collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": flag.ReviewID, "status": internal.ReviewPublished},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "flags", Value: 1}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&review)
+	if err != nil {
+		// Only published reviews can be reported
+		if _, deleteErr := flags.DeleteOne(Cxt, bson.M{"_id": flag.ID}); deleteErr != nil {
+			return review, fmt.Errorf("failed to remove flag: %s", deleteErr)
+		}
+		return review, err
+	}
+
+	if review.Flags < flagThreshold {
+		return review, nil
+	}
+
+	var before internal.Review
+	err = collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": review.ID, "revision": review.Revision, "status": internal.ReviewPublished},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: internal.ReviewPending},
+				{Key: "moderation", Value: internal.ModerationFlagged},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "uncounted", Value: ""}}},
+		},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return review, nil
+	}
+	if err != nil {
+		return review, fmt.Errorf("failed to hold review: %s", err)
+	}
+
+	review.Status = internal.ReviewPending
+	review.Moderation = internal.ModerationFlagged
+	review.Uncounted = false
+	if !counted(before) {
+		return review, nil
+	}
+
+	err = applyRatingChange(ratingChange{
+		RestaurantID: review.RestaurantID,
+		CreatedAt:    review.CreatedAt,
+		Remove:       true,
+		OldRating:    review.Rating,
+		OldAspects:   review.Aspects,
+	})
+	if err != nil {
+		return review, err
+	}
+
+	return review, nil
+}
+
+// HoldReview sends a review revision the NLP service refused to moderation instead
+// of publishing it.
+func HoldReview(id string, revision int, reason string) (bool, error) {
+	collection := getCollection("reviews")
+
+	result, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPendingAnalysis},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: internal.ReviewPending},
+				{Key: "moderation", Value: reason},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "analysisError", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to hold review: %s", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// GetModerationQueue returns one page of the reviews waiting for a moderator, whose
+// analysis failed or reported since one last looked at them, most reported first,
+// and their total.
+func GetModerationQueue(page int64, limit int64) ([]internal.ModerationItem, int64, error) {
+	collection := getCollection("reviews")
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": bson.M{"$in": bson.A{internal.ReviewPending, internal.ReviewAnalysisFailed}}},
+		bson.M{"flags": bson.M{"$gt": 0}},
+	}}
+
+	total, err := collection.CountDocuments(Cxt, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count reviews: %s", err)
+	}
+
+	cursor, err := collection.Aggregate(Cxt, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "flags", Value: -1},
+			{Key: "createdAt", Value: 1},
+			{Key: "_id", Value: 1},
+		}}},
+		{{Key: "$skip", Value: (page - 1) * limit}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "review_flags"},
+			{Key: "localField", Value: "_id"},
+			{Key: "foreignField", Value: "reviewId"},
+			{Key: "as", Value: "reports"},
+		}}},
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list moderation queue: %s", err)
+	}
+
+	items := []internal.ModerationItem{}
+	if err := cursor.All(Cxt, &items); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode moderation queue: %s", err)
+	}
+
+	return items, total, nil
+}
+
+// ModerateReview moves a review revision to status, published, hidden or rejected,
+// and clears its reports. A review that never got a rating, like one the NLP
+// service refused, is published with score. The restaurant aggregate follows the
+// review in or out of the published state. It returns the review as it was before.
+func ModerateReview(id string, revision int, status string, score *internal.ReviewScore) (internal.Review, error) {
+	collection := getCollection("reviews")
+
+	set := bson.D{{Key: "status", Value: status}}
+	unset := bson.D{
+		{Key: "flags", Value: ""},
+		{Key: "moderation", Value: ""},
+		{Key: "uncounted", Value: ""},
+	}
+	if score != nil {
+		set = append(set,
+			bson.E{Key: "rating", Value: score.Rating},
+			bson.E{Key: "policyVersion", Value: score.PolicyVersion},
+			bson.E{Key: "aspects", Value: score.Aspects},
+		)
+		unset = append(unset, bson.E{Key: "nlpRating", Value: ""})
+	}
+
+	var before internal.Review
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{
+			"_id":      id,
+			"revision": revision,
+			"status":   bson.M{"$ne": internal.ReviewPendingAnalysis},
+		},
+		bson.D{
+			{Key: "$set", Value: set},
+			{Key: "$unset", Value: unset},
+		},
+	).Decode(&before)
+	if err != nil {
+		return before, err
+	}
+
+	err = DeleteReviewFlags(id)
+	if err != nil {
+		return before, err
+	}
+
+	change := ratingChange{RestaurantID: before.RestaurantID, CreatedAt: before.CreatedAt}
+	if counted(before) && status != internal.ReviewPublished {
+		change.Remove = true
+		change.OldRating = before.Rating
+		change.OldAspects = before.Aspects
+	}
+	if !counted(before) && status == internal.ReviewPublished {
+		change.Add = true
+		change.NewRating = before.Rating
+		change.NewAspects = before.Aspects
+		if score != nil {
+			change.NewRating = score.Rating
+			change.NewAspects = score.Aspects
+		}
+	}
+	if !change.Remove && !change.Add {
+		return before, nil
+	}
+
+	err = applyRatingChange(change)
+	if err != nil {
+		return before, err
+	}
+
+	return before, nil
+}
+
+// DeleteReviewFlags removes the reports of a review.
+func DeleteReviewFlags(reviewID string) error {
+	_, err := getCollection("review_flags").DeleteMany(Cxt, bson.M{"reviewId": reviewID})
+	if err != nil {
+		return fmt.Errorf("failed to delete review flags: %s", err)
+	}
+
+	return nil
+}
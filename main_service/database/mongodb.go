@@ -2,12 +2,15 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"restaurant_reviews/config"
 	"restaurant_reviews/internal"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -16,11 +19,13 @@ import (
 var MongoDB *mongo.Client
 var Cxt context.Context
 
-func ConnectMongo(ctx context.Context) error {
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
+var databaseName string
+
+func ConnectMongo(ctx context.Context, cfg config.MongoConfig) error {
+	clientOptions := options.Client().ApplyURI(cfg.URI)
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to create MongoDB client: %s", err.Error())
 	}
 
 	err = client.Ping(ctx, nil)
@@ -29,12 +34,18 @@ func ConnectMongo(ctx context.Context) error {
 	}
 
 	MongoDB = client
-	Cxt = ctx
+	// ctx only bounds the startup, queries must outlive it
+	Cxt = context.Background()
+	databaseName = cfg.Database
 	return nil
 }
 
+func getCollection(name string) *mongo.Collection {
+	return MongoDB.Database(databaseName).Collection(name)
+}
+
 func GetUserID(email string) (string, error) {
-	collection := MongoDB.Database("restaurantdb_1").Collection("users")
+	collection := getCollection("users")
 	filter := bson.M{
 		"email": email,
 	}
@@ -52,11 +63,10 @@ func GetUserID(email string) (string, error) {
 	return user.ID, nil
 }
 
-func GetUser(email string, password string) (internal.User, error) {
-	collection := MongoDB.Database("restaurantdb_1").Collection("users")
+func GetUserByEmail(email string) (internal.User, error) {
+	collection := getCollection("users")
 	filter := bson.M{
-		"email":        email,
-		"passwordHash": password,
+		"email": email,
 	}
 
 	var user internal.User
@@ -69,36 +79,85 @@ func GetUser(email string, password string) (internal.User, error) {
 	return user, nil
 }
 
-func RegisterUser(email string, password string, role string) (interface{}, error) {
-	collection := MongoDB.Database("restaurantdb_1").Collection("users")
+func GetUserByID(id string) (internal.User, error) {
+	collection := getCollection("users")
+
+	var user internal.User
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return user, mongo.ErrNoDocuments
+	}
+
+	err = collection.FindOne(Cxt, bson.M{"_id": objID}).Decode(&user)
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+func UpdatePasswordHash(email string, passwordHash string) error {
+	collection := getCollection("users")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"email": email},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "passwordHash", Value: passwordHash}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %s", err)
+	}
+
+	return nil
+}
+
+// ErrEmailTaken is returned when a user with the email is already registered.
+var ErrEmailTaken = errors.New("email already registered")
+
+func RegisterUser(email string, passwordHash string, role string) (interface{}, error) {
+	collection := getCollection("users")
 	now := time.Now().UTC()
 
 	user := bson.D{
 		{Key: "email", Value: email},
 		{Key: "role", Value: role},
-		{Key: "passwordHash", Value: password},
+		{Key: "passwordHash", Value: passwordHash},
 		{Key: "registerAt", Value: now},
 	}
 
 	insertResult, err := collection.InsertOne(Cxt, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
-		log.Fatal(err)
-		return "Not Created", fmt.Errorf("failed to create user")
+		return nil, fmt.Errorf("failed to create user: %s", err)
 	}
 
 	return insertResult.InsertedID, nil
 }
 
-func CreateFeedBack(id string, userId string, restaurantId string, text string, rating float64) (internal.Review, error) {
-	collection := MongoDB.Database("restaurantdb_1").Collection("reviews")
+// ErrDuplicateReview is returned when the user already has a review of the
+// restaurant for the same visit.
+var ErrDuplicateReview = errors.New("review already exists")
+
+// CreateFeedBack stores the review as pending analysis and queues it for scoring.
+// The restaurant rating is only updated once a worker has scored the review.
+func CreateFeedBack(id string, userId string, restaurantId string, visit int, text string, rating float64, aspects map[string]float64) (internal.Review, error) {
+	collection := getCollection("reviews")
 	now := time.Now().UTC()
 
 	review := bson.D{
 		{Key: "_id", Value: id},
 		{Key: "userId", Value: userId},
 		{Key: "restaurantId", Value: restaurantId},
+		{Key: "visit", Value: visit},
 		{Key: "text", Value: text},
 		{Key: "rating", Value: rating},
+		{Key: "userRating", Value: rating},
+		{Key: "userAspects", Value: aspects},
+		{Key: "status", Value: internal.ReviewPendingAnalysis},
+		{Key: "revision", Value: 1},
 		{Key: "createdAt", Value: now},
 	}
 
@@ -106,68 +165,47 @@ func CreateFeedBack(id string, userId string, restaurantId string, text string,
 		ID:           id,
 		UserID:       userId,
 		RestaurantID: restaurantId,
+		Visit:        visit,
 		Text:         text,
 		Rating:       rating,
+		UserRating:   rating,
+		UserAspects:  aspects,
+		Status:       internal.ReviewPendingAnalysis,
+		Revision:     1,
 		CreatedAt:    now,
 	}
 
 	_, err := collection.InsertOne(Cxt, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return result, ErrDuplicateReview
+	}
 	if err != nil {
-		log.Fatal(err)
 		return result, fmt.Errorf("failed to create review: %s", err)
 	}
 
-	// Update restaurant rating
-	ratingsCollection := MongoDB.Database("restaurantdb_1").Collection("ratings")
-
-	// Get current rating or create new if not exists
-	var currentRating internal.Rating
-	err = ratingsCollection.FindOne(Cxt, bson.D{{Key: "restaurantId", Value: restaurantId}}).Decode(&currentRating)
-	if err != nil && err != mongo.ErrNoDocuments {
-		return result, fmt.Errorf("failed to get current rating: %s", err)
-	}
-
-	if err == mongo.ErrNoDocuments {
-		// First review for this restaurant
-		newRating := internal.Rating{
-			RestaurantID:  restaurantId,
-			AverageRating: rating,
-			ReviewCount:   1,
-		}
-		_, err = ratingsCollection.InsertOne(Cxt, newRating)
-		if err != nil {
-			return result, fmt.Errorf("failed to create initial rating: %s", err)
-		}
-	} else {
-		// Update existing rating
-		newCount := currentRating.ReviewCount + 1
-		newAverage := (currentRating.AverageRating*float64(currentRating.ReviewCount) + rating) / float64(newCount)
-
-		_, err = ratingsCollection.UpdateOne(
-			Cxt,
-			bson.D{{Key: "restaurantId", Value: restaurantId}},
-			bson.D{{Key: "$set", Value: bson.D{
-				{Key: "averageRating", Value: newAverage},
-				{Key: "reviewCount", Value: newCount},
-			}}},
-		)
-		if err != nil {
-			return result, fmt.Errorf("failed to update rating: %s", err)
+	err = EnqueueNLPJob(id, 1, rating)
+	if err != nil {
+		// A review without a job would stay pending forever
+		if _, deleteErr := collection.DeleteOne(Cxt, bson.M{"_id": id}); deleteErr != nil {
+			log.Printf("Failed to roll back review %s: %v", id, deleteErr)
 		}
+		return result, err
 	}
 
 	return result, nil
 }
 
-func DeleteUser(id int) (int, error) {
-	collection := MongoDB.Database("restaurantdb_1").Collection("users")
-
-	review := bson.D{{Key: "user_id", Value: id}}
-	_, err := collection.DeleteOne(Cxt, review)
+func DeleteUser(id primitive.ObjectID) error {
+	collection := getCollection("users")
 
+	result, err := collection.DeleteOne(Cxt, bson.D{{Key: "_id", Value: id}})
 	if err != nil {
-		return id, fmt.Errorf("failed to delete user: %s", err)
+		return fmt.Errorf("failed to delete user: %s", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
 	}
 
-	return id, nil
+	return nil
 }
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaveNLPResult stores the analysis of a review, replacing the previous one when
+// the review was edited.
+func SaveNLPResult(result internal.NLPResult) error {
+	collection := getCollection("nlp_results")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"reviewId": result.ReviewID},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "sentiment", Value: result.Sentiment},
+			{Key: "polarity", Value: result.Polarity},
+			{Key: "language", Value: result.Language},
+			{Key: "keywords", Value: result.Keywords},
+			{Key: "rating", Value: result.Rating},
+			{Key: "aspects", Value: result.Aspects},
+			{Key: "analyzedAt", Value: result.AnalyzedAt},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save nlp result: %s", err)
+	}
+
+	return nil
+}
+
+func GetNLPResult(reviewID string) (internal.NLPResult, error) {
+	collection := getCollection("nlp_results")
+
+	var result internal.NLPResult
+	err := collection.FindOne(Cxt, bson.M{"reviewId": reviewID}).Decode(&result)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func DeleteNLPResult(reviewID string) error {
+	collection := getCollection("nlp_results")
+
+	_, err := collection.DeleteOne(Cxt, bson.M{"reviewId": reviewID})
+	if err != nil {
+		return fmt.Errorf("failed to delete nlp result: %s", err)
+	}
+
+	return nil
+}
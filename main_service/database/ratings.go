@@ -0,0 +1,499 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ranking parameters. A review's weight halves every decayHalfLife after it was
+// written, and priorWeight reviews at the mean rating of all restaurants are added
+// to every restaurant's ranking score.
+var (
+	decayHalfLife = 180 * 24 * time.Hour
+	priorWeight   = 10.0
+)
+
+func SetRanking(halfLife time.Duration, prior float64) {
+	decayHalfLife = halfLife
+	priorWeight = prior
+}
+
+// reviewWeight is the decayed weight at now of a review written at createdAt.
+func reviewWeight(createdAt time.Time, now time.Time) float64 {
+	return math.Pow(0.5, float64(now.Sub(createdAt))/float64(decayHalfLife))
+}
+
+// decayFactor is the aggregation expression for how much a weight stored at the
+// time in field has decayed by now.
+func decayFactor(field string, now time.Time) bson.D {
+	return bson.D{{Key: "$pow", Value: bson.A{
+		0.5,
+		bson.D{{Key: "$divide", Value: bson.A{
+			bson.D{{Key: "$subtract", Value: bson.A{now, bson.D{{Key: "$ifNull", Value: bson.A{field, now}}}}}},
+			decayHalfLife.Milliseconds(),
+		}}},
+	}}}
+}
+
+// ratingChange describes how one review's contribution to its restaurant aggregate
+// changes: removed with OldRating and OldAspects when Remove is set, added with
+// NewRating and NewAspects when Add is set. CreatedAt of the review picks its
+// monthly bucket and decay weight.
+type ratingChange struct {
+	RestaurantID string
+	CreatedAt    time.Time
+	Remove       bool
+	OldRating    float64
+	OldAspects   map[string]float64
+	Add          bool
+	NewRating    float64
+	NewAspects   map[string]float64
+}
+
+// starBucket is the histogram index, 0 for one star to 4 for five stars, of a rating.
+func starBucket(rating float64) int {
+	return min(max(int(math.Floor(rating+0.5)), 1), 5) - 1
+}
+
+// applyRatingChange updates the restaurant aggregate for one review in a single
+// pipeline update, so concurrent writers can't lose each other's changes. The
+// aggregate is created on first use and removed when its last review goes away.
+// Besides count and sum it keeps the star histogram, the review's monthly bucket
+// and the time-decayed totals, brought forward to now and weighted by review age.
+func applyRatingChange(change ratingChange) error {
+	collection := getCollection("ratings")
+	filter := bson.D{{Key: "restaurantId", Value: change.RestaurantID}}
+
+	countDelta := 0
+	sumDelta := 0.0
+	histogramDelta := make([]int, 5)
+	if change.Remove {
+		countDelta--
+		sumDelta -= change.OldRating
+		histogramDelta[starBucket(change.OldRating)]--
+	}
+	if change.Add {
+		countDelta++
+		sumDelta += change.NewRating
+		histogramDelta[starBucket(change.NewRating)]++
+	}
+
+	aspectCounts := map[string]int{}
+	aspectSums := map[string]float64{}
+	if change.Remove {
+		for aspect, score := range change.OldAspects {
+			aspectCounts[aspect]--
+			aspectSums[aspect] -= score
+		}
+	}
+	if change.Add {
+		for aspect, score := range change.NewAspects {
+			aspectCounts[aspect]++
+			aspectSums[aspect] += score
+		}
+	}
+
+	// Aspect totals move with the other totals, their averages follow in the next stage
+	aspectTotals := bson.D{}
+	aspectAverages := bson.D{}
+	for _, aspect := range internal.Aspects {
+		if _, ok := aspectCounts[aspect]; !ok {
+			continue
+		}
+		field := "aspects." + aspect
+		aspectTotals = append(aspectTotals,
+			bson.E{Key: field + ".count", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$" + field + ".count", 0}}},
+				aspectCounts[aspect],
+			}}}},
+			bson.E{Key: field + ".sum", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$" + field + ".sum", 0}}},
+				aspectSums[aspect],
+			}}}},
+		)
+		aspectAverages = append(aspectAverages, bson.E{Key: field, Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$gt", Value: bson.A{"$" + field + ".count", 0}}},
+			bson.D{{Key: "$mergeObjects", Value: bson.A{
+				"$" + field,
+				bson.D{{Key: "average", Value: bson.D{{Key: "$divide", Value: bson.A{"$" + field + ".sum", "$" + field + ".count"}}}}},
+			}}},
+			"$$REMOVE",
+		}}}})
+	}
+
+	now := time.Now().UTC()
+	weight := reviewWeight(change.CreatedAt, now)
+	month := "monthly." + change.CreatedAt.UTC().Format("2006-01")
+
+	totals := bson.D{
+		// Aggregates written before ratingSum existed only have the average
+		{Key: "ratingSum", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{
+				"$ratingSum",
+				bson.D{{Key: "$multiply", Value: bson.A{
+					bson.D{{Key: "$ifNull", Value: bson.A{"$averageRating", 0}}},
+					bson.D{{Key: "$ifNull", Value: bson.A{"$reviewCount", 0}}},
+				}}},
+			}}},
+			sumDelta,
+		}}}},
+		{Key: "reviewCount", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$reviewCount", 0}}},
+			countDelta,
+		}}}},
+		{Key: "histogram", Value: bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$range", Value: bson.A{0, 5}}}},
+			{Key: "as", Value: "star"},
+			{Key: "in", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{bson.D{{Key: "$arrayElemAt", Value: bson.A{"$histogram", "$$star"}}}, 0}}},
+				bson.D{{Key: "$arrayElemAt", Value: bson.A{histogramDelta, "$$star"}}},
+			}}}},
+		}}}},
+		{Key: month + ".count", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$" + month + ".count", 0}}},
+			countDelta,
+		}}}},
+		{Key: month + ".sum", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$" + month + ".sum", 0}}},
+			sumDelta,
+		}}}},
+		{Key: "decayedSum", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$multiply", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$decayedSum", 0}}},
+				decayFactor("$decayedAt", now),
+			}}},
+			sumDelta * weight,
+		}}}},
+		{Key: "decayedWeight", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$multiply", Value: bson.A{
+				bson.D{{Key: "$ifNull", Value: bson.A{"$decayedWeight", 0}}},
+				decayFactor("$decayedAt", now),
+			}}},
+			float64(countDelta) * weight,
+		}}}},
+		{Key: "decayedAt", Value: now},
+		{Key: "decayHalfLife", Value: decayHalfLife.Milliseconds()},
+	}
+	totals = append(totals, aspectTotals...)
+
+	averages := bson.D{
+		{Key: "averageRating", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$gt", Value: bson.A{"$reviewCount", 0}}},
+			bson.D{{Key: "$divide", Value: bson.A{"$ratingSum", "$reviewCount"}}},
+			0,
+		}}}},
+		// Drop months and aspects whose last review went away
+		{Key: month, Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$gt", Value: bson.A{"$" + month + ".count", 0}}},
+			"$" + month,
+			"$$REMOVE",
+		}}}},
+	}
+	averages = append(averages, aspectAverages...)
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "aspects", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$aspects", bson.D{{Key: "$literal", Value: bson.D{}}}}}}},
+		}}},
+		{{Key: "$set", Value: totals}},
+		{{Key: "$set", Value: averages}},
+	}
+
+	updateOptions := options.Update().SetUpsert(true)
+
+	_, err := collection.UpdateOne(Cxt, filter, update, updateOptions)
+	if mongo.IsDuplicateKeyError(err) {
+		// Two first reviews raced to create the aggregate, the loser now finds it
+		_, err = collection.UpdateOne(Cxt, filter, update, updateOptions)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update rating: %s", err)
+	}
+
+	if countDelta < 0 {
+		_, err = collection.DeleteOne(Cxt, bson.D{
+			{Key: "restaurantId", Value: change.RestaurantID},
+			{Key: "reviewCount", Value: bson.D{{Key: "$lte", Value: 0}}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete rating: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// RebuildRatings recomputes every restaurant aggregate from its published reviews
+// and drops aggregates of restaurants that have none left. Reviews published while
+// it runs may be counted on top of the rebuilt totals, so it is meant for quiet
+// periods such as the end of a rescore job. Uncounted reviews are left for their
+// scoring job to count.
+func RebuildRatings() error {
+	reviews := getCollection("reviews")
+	countedReviews := bson.M{"status": internal.ReviewPublished, "uncounted": bson.M{"$ne": true}}
+	now := time.Now().UTC()
+	weight := decayFactor("$createdAt", now)
+
+	// Histogram counts are summed per star, then collected into an array
+	starCounts := bson.D{}
+	starSums := bson.D{}
+	histogram := bson.A{}
+	for star := 1; star <= 5; star++ {
+		field := fmt.Sprintf("star%d", star)
+		lower, upper := float64(star)-0.5, float64(star)+0.5
+		if star == 1 {
+			lower = math.Inf(-1)
+		}
+		if star == 5 {
+			upper = math.Inf(1)
+		}
+		starCounts = append(starCounts, bson.E{Key: field, Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$and", Value: bson.A{
+				bson.D{{Key: "$gte", Value: bson.A{"$rating", lower}}},
+				bson.D{{Key: "$lt", Value: bson.A{"$rating", upper}}},
+			}}},
+			1,
+			0,
+		}}}}}})
+		starSums = append(starSums, bson.E{Key: field, Value: bson.D{{Key: "$sum", Value: "$" + field}}})
+		histogram = append(histogram, "$"+field)
+	}
+
+	// Aspect counts and sums likewise, then kept for the aspects that were rated
+	aspectCounts := bson.D{}
+	aspectSums := bson.D{}
+	aspects := bson.A{}
+	for _, aspect := range internal.Aspects {
+		countField, sumField := aspect+"Count", aspect+"Sum"
+		aspectCounts = append(aspectCounts,
+			bson.E{Key: countField, Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$aspects." + aspect}}, "missing"}}},
+				0,
+				1,
+			}}}}}},
+			bson.E{Key: sumField, Value: bson.D{{Key: "$sum", Value: "$aspects." + aspect}}},
+		)
+		aspectSums = append(aspectSums,
+			bson.E{Key: countField, Value: bson.D{{Key: "$sum", Value: "$" + countField}}},
+			bson.E{Key: sumField, Value: bson.D{{Key: "$sum", Value: "$" + sumField}}},
+		)
+		aspects = append(aspects, bson.D{
+			{Key: "k", Value: aspect},
+			{Key: "v", Value: bson.D{
+				{Key: "count", Value: "$" + countField},
+				{Key: "sum", Value: "$" + sumField},
+				{Key: "average", Value: bson.D{{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$gt", Value: bson.A{"$" + countField, 0}}},
+					bson.D{{Key: "$divide", Value: bson.A{"$" + sumField, "$" + countField}}},
+					0,
+				}}}},
+			}},
+		})
+	}
+
+	monthGroup := bson.D{
+		{Key: "_id", Value: bson.D{
+			{Key: "restaurantId", Value: "$restaurantId"},
+			{Key: "month", Value: bson.D{{Key: "$dateToString", Value: bson.D{
+				{Key: "format", Value: "%Y-%m"},
+				{Key: "date", Value: "$createdAt"},
+			}}}},
+		}},
+		{Key: "ratingSum", Value: bson.D{{Key: "$sum", Value: "$rating"}}},
+		{Key: "reviewCount", Value: bson.D{{Key: "$sum", Value: 1}}},
+		{Key: "decayedSum", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$multiply", Value: bson.A{"$rating", weight}}}}}},
+		{Key: "decayedWeight", Value: bson.D{{Key: "$sum", Value: weight}}},
+	}
+	monthGroup = append(monthGroup, starCounts...)
+	monthGroup = append(monthGroup, aspectCounts...)
+
+	restaurantGroup := bson.D{
+		{Key: "_id", Value: "$_id.restaurantId"},
+		{Key: "ratingSum", Value: bson.D{{Key: "$sum", Value: "$ratingSum"}}},
+		{Key: "reviewCount", Value: bson.D{{Key: "$sum", Value: "$reviewCount"}}},
+		{Key: "decayedSum", Value: bson.D{{Key: "$sum", Value: "$decayedSum"}}},
+		{Key: "decayedWeight", Value: bson.D{{Key: "$sum", Value: "$decayedWeight"}}},
+		{Key: "monthly", Value: bson.D{{Key: "$push", Value: bson.D{
+			{Key: "k", Value: "$_id.month"},
+			{Key: "v", Value: bson.D{{Key: "count", Value: "$reviewCount"}, {Key: "sum", Value: "$ratingSum"}}},
+		}}}},
+	}
+	restaurantGroup = append(restaurantGroup, starSums...)
+	restaurantGroup = append(restaurantGroup, aspectSums...)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: countedReviews}},
+		{{Key: "$group", Value: monthGroup}},
+		{{Key: "$group", Value: restaurantGroup}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "restaurantId", Value: "$_id"},
+			{Key: "ratingSum", Value: 1},
+			{Key: "reviewCount", Value: 1},
+			{Key: "averageRating", Value: bson.D{{Key: "$divide", Value: bson.A{"$ratingSum", "$reviewCount"}}}},
+			{Key: "histogram", Value: histogram},
+			{Key: "monthly", Value: bson.D{{Key: "$arrayToObject", Value: "$monthly"}}},
+			{Key: "aspects", Value: bson.D{{Key: "$arrayToObject", Value: bson.D{{Key: "$filter", Value: bson.D{
+				{Key: "input", Value: aspects},
+				{Key: "cond", Value: bson.D{{Key: "$gt", Value: bson.A{"$$this.v.count", 0}}}},
+			}}}}}},
+			{Key: "decayedSum", Value: 1},
+			{Key: "decayedWeight", Value: 1},
+			{Key: "decayedAt", Value: now},
+			{Key: "decayHalfLife", Value: decayHalfLife.Milliseconds()},
+		}}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "ratings"},
+			{Key: "on", Value: "restaurantId"},
+			{Key: "whenMatched", Value: "merge"},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}},
+	}
+
+	cursor, err := reviews.Aggregate(Cxt, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild ratings: %s", err)
+	}
+	cursor.Close(Cxt)
+
+	rated, err := reviews.Distinct(Cxt, "restaurantId", countedReviews)
+	if err != nil {
+		return fmt.Errorf("failed to list rated restaurants: %s", err)
+	}
+
+	_, err = getCollection("ratings").DeleteMany(Cxt, bson.M{"restaurantId": bson.M{"$nin": rated}})
+	if err != nil {
+		return fmt.Errorf("failed to delete stale ratings: %s", err)
+	}
+
+	return nil
+}
+
+// EnsureRatingAggregates rebuilds the aggregates when some were decayed with another
+// half-life than the configured one, or predate time decay, the histogram or
+// aspects.
+func EnsureRatingAggregates() error {
+	collection := getCollection("ratings")
+
+	count, err := collection.CountDocuments(
+		Cxt,
+		bson.M{"$or": bson.A{
+			bson.M{"decayHalfLife": bson.M{"$ne": decayHalfLife.Milliseconds()}},
+			bson.M{"histogram": bson.M{"$exists": false}},
+			bson.M{"aspects": bson.M{"$exists": false}},
+		}},
+		options.Count().SetLimit(1),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to check rating decay: %s", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	log.Printf("Rebuilding restaurant ratings")
+	return RebuildRatings()
+}
+
+func GetRating(restaurantID string) (internal.Rating, error) {
+	collection := getCollection("ratings")
+
+	var rating internal.Rating
+	err := collection.FindOne(Cxt, bson.M{"restaurantId": restaurantID}).Decode(&rating)
+	if err != nil {
+		return rating, err
+	}
+
+	return rating, nil
+}
+
+// GetTopRestaurants returns up to limit rated restaurants, optionally of one
+// category, by ranking score: the mean of their time-decayed ratings pulled
+// towards the mean rating of all restaurants by priorWeight reviews.
+func GetTopRestaurants(categoryID string, limit int64) ([]internal.RankedRestaurant, error) {
+	collection := getCollection("ratings")
+	now := time.Now().UTC()
+
+	prior, err := meanRating()
+	if err != nil {
+		return nil, err
+	}
+
+	factor := decayFactor("$decayedAt", now)
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "rankingScore", Value: bson.D{{Key: "$divide", Value: bson.A{
+			bson.D{{Key: "$add", Value: bson.A{
+				priorWeight * prior,
+				bson.D{{Key: "$multiply", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$decayedSum", 0}}}, factor}}},
+			}}},
+			bson.D{{Key: "$add", Value: bson.A{
+				priorWeight,
+				bson.D{{Key: "$multiply", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$decayedWeight", 0}}}, factor}}},
+			}}},
+		}}}}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "restaurants"},
+			{Key: "localField", Value: "restaurantId"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "restaurant"},
+		}}},
+		{{Key: "$unwind", Value: "$restaurant"}},
+	}
+	if categoryID != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "restaurant.categoryId", Value: categoryID}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "rankingScore", Value: -1}, {Key: "restaurantId", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+		bson.D{{Key: "$replaceWith", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+			"$restaurant",
+			bson.D{{Key: "rankingScore", Value: "$rankingScore"}, {Key: "rating", Value: "$$ROOT"}},
+		}}}}},
+		bson.D{{Key: "$unset", Value: bson.A{"rating.restaurant", "rating.rankingScore"}}},
+	)
+
+	cursor, err := collection.Aggregate(Cxt, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank restaurants: %s", err)
+	}
+
+	restaurants := []internal.RankedRestaurant{}
+	if err := cursor.All(Cxt, &restaurants); err != nil {
+		return nil, fmt.Errorf("failed to decode restaurants: %s", err)
+	}
+
+	return restaurants, nil
+}
+
+// meanRating is the plain mean of all published review ratings.
+func meanRating() (float64, error) {
+	collection := getCollection("ratings")
+
+	cursor, err := collection.Aggregate(Cxt, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "ratingSum", Value: bson.D{{Key: "$sum", Value: "$ratingSum"}}},
+			{Key: "reviewCount", Value: bson.D{{Key: "$sum", Value: "$reviewCount"}}},
+		}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute mean rating: %s", err)
+	}
+
+	var totals []internal.Rating
+	if err := cursor.All(Cxt, &totals); err != nil {
+		return 0, fmt.Errorf("failed to decode mean rating: %s", err)
+	}
+	if len(totals) == 0 || totals[0].ReviewCount == 0 {
+		return 0, nil
+	}
+
+	return totals[0].RatingSum / float64(totals[0].ReviewCount), nil
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"restaurant_reviews/config"
+	"restaurant_reviews/internal"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectTestDB connects to the MongoDB at MONGO_URI and migrates a fresh database
+// that is dropped when the test ends. Without MONGO_URI the test is skipped.
+func connectTestDB(t *testing.T) {
+	t.Helper()
+
+	uri := os.Getenv("MONGO_URI")
+	if uri == "" {
+		t.Skip("MONGO_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	name := fmt.Sprintf("restaurant_reviews_test_%d", time.Now().UnixNano())
+	err := ConnectMongo(ctx, config.MongoConfig{URI: uri, Database: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := MongoDB.Database(name).Drop(context.Background()); err != nil {
+			t.Logf("Failed to drop %s: %v", name, err)
+		}
+		_ = MongoDB.Disconnect(context.Background())
+	})
+
+	err = RunMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runParallel calls fn for 0..n-1 from n goroutines at once and fails the test with
+// the first error.
+func runParallel(t *testing.T, n int, fn func(i int) error) {
+	t.Helper()
+
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs <- fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkRating(t *testing.T, restaurantID string, count int, sum float64) {
+	t.Helper()
+
+	rating, err := GetRating(restaurantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rating.ReviewCount != count {
+		t.Errorf("reviewCount = %d, want %d", rating.ReviewCount, count)
+	}
+	if rating.RatingSum != sum {
+		t.Errorf("ratingSum = %v, want %v", rating.RatingSum, sum)
+	}
+	if want := sum / float64(count); rating.AverageRating != want {
+		t.Errorf("averageRating = %v, want %v", rating.AverageRating, want)
+	}
+
+	histogramTotal := 0
+	for _, stars := range rating.Histogram {
+		histogramTotal += stars
+	}
+	if histogramTotal != count {
+		t.Errorf("histogram counts %d reviews, want %d", histogramTotal, count)
+	}
+}
+
+func TestConcurrentRatingChanges(t *testing.T) {
+	connectTestDB(t)
+
+	const n = 300
+	restaurantID := "concurrent-changes"
+	createdAt := time.Now().UTC()
+	stars := func(i int) float64 { return float64(i%5 + 1) }
+
+	runParallel(t, n, func(i int) error {
+		return applyRatingChange(ratingChange{
+			RestaurantID: restaurantID,
+			CreatedAt:    createdAt,
+			Add:          true,
+			NewRating:    stars(i),
+		})
+	})
+
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		sum += stars(i)
+	}
+	checkRating(t, restaurantID, n, sum)
+
+	// Take the first half out while as many new reviews come in
+	runParallel(t, n, func(i int) error {
+		if i < n/2 {
+			return applyRatingChange(ratingChange{
+				RestaurantID: restaurantID,
+				CreatedAt:    createdAt,
+				Remove:       true,
+				OldRating:    stars(i),
+			})
+		}
+		return applyRatingChange(ratingChange{
+			RestaurantID: restaurantID,
+			CreatedAt:    createdAt,
+			Add:          true,
+			NewRating:    stars(i + 1),
+		})
+	})
+
+	for i := 0; i < n; i++ {
+		if i < n/2 {
+			sum -= stars(i)
+		} else {
+			sum += stars(i + 1)
+		}
+	}
+	checkRating(t, restaurantID, n, sum)
+}
+
+func TestConcurrentPublishReview(t *testing.T) {
+	connectTestDB(t)
+
+	const n = 300
+	restaurantID := "concurrent-publish"
+	now := time.Now().UTC()
+
+	reviews := make([]interface{}, n)
+	for i := range reviews {
+		reviews[i] = internal.Review{
+			ID:           fmt.Sprintf("review-%d", i),
+			UserID:       fmt.Sprintf("user-%d", i),
+			RestaurantID: restaurantID,
+			Visit:        1,
+			Text:         "test",
+			Status:       internal.ReviewPendingAnalysis,
+			Revision:     1,
+			CreatedAt:    now,
+		}
+	}
+	_, err := getCollection("reviews").InsertMany(Cxt, reviews)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every review is published twice at once, as by two workers holding its job
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		sum += float64(i%5 + 1)
+	}
+	runParallel(t, 2*n, func(i int) error {
+		i %= n
+		_, err := PublishReview(fmt.Sprintf("review-%d", i), 1, internal.ReviewScore{
+			Rating:        float64(i%5 + 1),
+			PolicyVersion: "user",
+		})
+		return err
+	})
+
+	checkRating(t, restaurantID, n, sum)
+}
+
+func TestCountReviewAfterFailedPublish(t *testing.T) {
+	connectTestDB(t)
+
+	restaurantID := "count-after-failure"
+
+	// As left by a publish whose aggregate update failed
+	_, err := getCollection("reviews").InsertOne(Cxt, internal.Review{
+		ID:           "uncounted",
+		UserID:       "user",
+		RestaurantID: restaurantID,
+		Visit:        1,
+		Text:         "test",
+		Rating:       4,
+		Status:       internal.ReviewPublished,
+		Uncounted:    true,
+		Revision:     1,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retried job publishes again while other retries count it
+	runParallel(t, 20, func(i int) error {
+		if i == 0 {
+			_, err := PublishReview("uncounted", 1, internal.ReviewScore{Rating: 4, PolicyVersion: "user"})
+			return err
+		}
+		_, err := CountReview("uncounted", 1)
+		return err
+	})
+
+	checkRating(t, restaurantID, 1, 4)
+
+	review, err := GetReview("uncounted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.Uncounted {
+		t.Error("review is still uncounted")
+	}
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrRescoreRunning = errors.New("a rescore job is already running")
+
+// CreateRescoreJob stores a new running job locked for lease. The unique index on
+// running jobs makes it fail with ErrRescoreRunning while another job hasn't
+// finished and its owner is still renewing it.
+func CreateRescoreJob(job internal.RescoreJob, lease time.Duration) (internal.RescoreJob, error) {
+	collection := getCollection("rescore_jobs")
+
+	lockedUntil := time.Now().UTC().Add(lease)
+	job.LockedUntil = &lockedUntil
+
+	_, err := collection.InsertOne(Cxt, job)
+	if mongo.IsDuplicateKeyError(err) {
+		// The running job may have been left behind by a process that died
+		err = FailInterruptedRescoreJobs()
+		if err != nil {
+			return job, err
+		}
+		_, err = collection.InsertOne(Cxt, job)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return job, ErrRescoreRunning
+	}
+	if err != nil {
+		return job, fmt.Errorf("failed to create rescore job: %s", err)
+	}
+
+	return job, nil
+}
+
+func GetRescoreJob(id string) (internal.RescoreJob, error) {
+	collection := getCollection("rescore_jobs")
+
+	var job internal.RescoreJob
+	err := collection.FindOne(Cxt, bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		return job, err
+	}
+
+	return job, nil
+}
+
+func UpdateRescoreProgress(id string, processed int64, failed int64) error {
+	collection := getCollection("rescore_jobs")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id},
+		bson.D{{Key: "$inc", Value: bson.D{
+			{Key: "processed", Value: processed},
+			{Key: "failed", Value: failed},
+		}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update rescore job: %s", err)
+	}
+
+	return nil
+}
+
+// RenewRescoreJob extends the lock of a running job held by owner. It returns
+// mongo.ErrNoDocuments when the job is no longer running under owner.
+func RenewRescoreJob(id string, owner string, lease time.Duration) error {
+	collection := getCollection("rescore_jobs")
+
+	result, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "owner": owner, "status": internal.RescoreRunning},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lockedUntil", Value: time.Now().UTC().Add(lease)}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to renew rescore job: %s", err)
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// FinishRescoreJob marks the job finished, or failed when jobErr is set. A job that
+// was already failed as interrupted is left as it is.
+func FinishRescoreJob(id string, jobErr error) error {
+	collection := getCollection("rescore_jobs")
+
+	set := bson.D{
+		{Key: "status", Value: internal.RescoreFinished},
+		{Key: "finishedAt", Value: time.Now().UTC()},
+	}
+	if jobErr != nil {
+		set[0].Value = internal.RescoreFailed
+		set = append(set, bson.E{Key: "error", Value: jobErr.Error()})
+	}
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "status": internal.RescoreRunning},
+		bson.D{
+			{Key: "$set", Value: set},
+			{Key: "$unset", Value: bson.D{{Key: "lockedUntil", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish rescore job: %s", err)
+	}
+
+	return nil
+}
+
+// FailInterruptedRescoreJobs marks running jobs whose lock ran out as failed, so a
+// new job can be started. Jobs that a live process, on this or another replica,
+// keeps renewing are left alone.
+func FailInterruptedRescoreJobs() error {
+	collection := getCollection("rescore_jobs")
+	now := time.Now().UTC()
+
+	_, err := collection.UpdateMany(
+		Cxt,
+		bson.M{
+			"status": internal.RescoreRunning,
+			// Jobs from before locking have no lockedUntil
+			"$or": bson.A{
+				bson.M{"lockedUntil": bson.M{"$lte": now}},
+				bson.M{"lockedUntil": bson.M{"$exists": false}},
+			},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: internal.RescoreFailed},
+				{Key: "error", Value: "interrupted, its process stopped renewing it"},
+				{Key: "finishedAt", Value: now},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "lockedUntil", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fail interrupted rescore jobs: %s", err)
+	}
+
+	return nil
+}
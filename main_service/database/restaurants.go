@@ -0,0 +1,217 @@
+package database
+
+import (
+	"fmt"
+	"restaurant_reviews/internal"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func CategoryExists(id string) (bool, error) {
+	collection := getCollection("categories")
+
+	count, err := collection.CountDocuments(Cxt, bson.M{"_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check category: %s", err)
+	}
+
+	return count > 0, nil
+}
+
+func CreateRestaurant(restaurant internal.Restaurant) (internal.Restaurant, error) {
+	collection := getCollection("restaurants")
+
+	_, err := collection.InsertOne(Cxt, restaurant)
+	if err != nil {
+		return restaurant, fmt.Errorf("failed to create restaurant: %s", err)
+	}
+
+	return restaurant, nil
+}
+
+func GetRestaurant(id string) (internal.Restaurant, error) {
+	collection := getCollection("restaurants")
+
+	var restaurant internal.Restaurant
+	err := collection.FindOne(Cxt, bson.M{"_id": id}).Decode(&restaurant)
+	if err != nil {
+		return restaurant, err
+	}
+
+	return restaurant, nil
+}
+
+// GetRestaurants returns one page of the restaurants by name with their ratings,
+// and how many restaurants there are in all. minAspects keeps only restaurants
+// whose average for each given aspect is at least the given score.
+func GetRestaurants(minAspects map[string]float64, page int64, limit int64) ([]internal.RestaurantWithRating, int64, error) {
+	collection := getCollection("restaurants")
+
+	// Filtering on aspects needs the ratings joined first, otherwise only the page
+	// is joined
+	var match mongo.Pipeline
+	if len(minAspects) > 0 {
+		filter := bson.D{}
+		for aspect, score := range minAspects {
+			filter = append(filter, bson.E{Key: "rating.aspects." + aspect + ".average", Value: bson.D{{Key: "$gte", Value: score}}})
+		}
+		match = append(ratingLookupStages(), bson.D{{Key: "$match", Value: filter}})
+	}
+
+	total, err := countRestaurants(match)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}}}}
+	pipeline = append(pipeline, match...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$skip", Value: (page - 1) * limit}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+	if len(match) == 0 {
+		pipeline = append(pipeline, ratingLookupStages()...)
+	}
+
+	cursor, err := collection.Aggregate(Cxt, pipeline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list restaurants: %s", err)
+	}
+
+	restaurants := []internal.RestaurantWithRating{}
+	if err := cursor.All(Cxt, &restaurants); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode restaurants: %s", err)
+	}
+
+	return restaurants, total, nil
+}
+
+// countRestaurants counts the restaurants that make it through the match stages.
+func countRestaurants(match mongo.Pipeline) (int64, error) {
+	collection := getCollection("restaurants")
+
+	if len(match) == 0 {
+		total, err := collection.CountDocuments(Cxt, bson.M{})
+		if err != nil {
+			return 0, fmt.Errorf("failed to count restaurants: %s", err)
+		}
+		return total, nil
+	}
+
+	cursor, err := collection.Aggregate(Cxt, append(match, bson.D{{Key: "$count", Value: "total"}}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count restaurants: %s", err)
+	}
+
+	var counts []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cursor.All(Cxt, &counts); err != nil {
+		return 0, fmt.Errorf("failed to decode restaurant count: %s", err)
+	}
+	if len(counts) == 0 {
+		return 0, nil
+	}
+
+	return counts[0].Total, nil
+}
+
+func GetNearbyRestaurants(point internal.Location, radius float64) ([]internal.NearbyRestaurant, error) {
+	collection := getCollection("restaurants")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.D{
+			{Key: "near", Value: bson.D{
+				{Key: "type", Value: "Point"},
+				{Key: "coordinates", Value: point.Coordinates},
+			}},
+			{Key: "key", Value: "location"},
+			{Key: "distanceField", Value: "distance"},
+			{Key: "maxDistance", Value: radius},
+			{Key: "spherical", Value: true},
+		}}},
+	}
+	pipeline = append(pipeline, ratingLookupStages()...)
+
+	cursor, err := collection.Aggregate(Cxt, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search nearby restaurants: %s", err)
+	}
+
+	restaurants := []internal.NearbyRestaurant{}
+	if err := cursor.All(Cxt, &restaurants); err != nil {
+		return nil, fmt.Errorf("failed to decode restaurants: %s", err)
+	}
+
+	return restaurants, nil
+}
+
+func UpdateRestaurant(restaurant internal.Restaurant) (internal.Restaurant, error) {
+	collection := getCollection("restaurants")
+
+	result, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": restaurant.ID},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "name", Value: restaurant.Name},
+			{Key: "categoryId", Value: restaurant.CategoryID},
+			{Key: "location", Value: restaurant.Location},
+		}}},
+	)
+	if err != nil {
+		return restaurant, fmt.Errorf("failed to update restaurant: %s", err)
+	}
+
+	if result.MatchedCount == 0 {
+		return restaurant, mongo.ErrNoDocuments
+	}
+
+	return restaurant, nil
+}
+
+func DeleteRestaurant(id string) error {
+	collection := getCollection("restaurants")
+
+	result, err := collection.DeleteOne(Cxt, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete restaurant: %s", err)
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	err = deleteRestaurantReviews(id)
+	if err != nil {
+		return err
+	}
+
+	// Drop the rating aggregate together with the restaurant
+	ratingsCollection := getCollection("ratings")
+	_, err = ratingsCollection.DeleteOne(Cxt, bson.M{"restaurantId": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete restaurant rating: %s", err)
+	}
+
+	_, err = getCollection("favorites").DeleteMany(Cxt, bson.M{"restaurantId": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete restaurant favorites: %s", err)
+	}
+
+	// The revision changes so a reorder read before the pull can't put it back
+	_, err = getCollection("collections").UpdateMany(
+		Cxt,
+		bson.M{"entries.restaurantId": id},
+		bson.D{
+			{Key: "$pull", Value: bson.D{{Key: "entries", Value: bson.D{{Key: "restaurantId", Value: id}}}}},
+			{Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove restaurant from collections: %s", err)
+	}
+
+	return nil
+}
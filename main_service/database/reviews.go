@@ -0,0 +1,483 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"restaurant_reviews/internal"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// counted reports whether the review's rating is in the restaurant aggregate.
+func counted(review internal.Review) bool {
+	return review.Status == internal.ReviewPublished && !review.Uncounted
+}
+
+func GetReview(id string) (internal.Review, error) {
+	collection := getCollection("reviews")
+
+	var review internal.Review
+	err := collection.FindOne(Cxt, bson.M{"_id": id}).Decode(&review)
+	if err != nil {
+		return review, err
+	}
+
+	return review, nil
+}
+
+// GetLatestUserReview returns the user's review of the restaurant with the highest
+// visit number.
+func GetLatestUserReview(userID string, restaurantID string) (internal.Review, error) {
+	collection := getCollection("reviews")
+
+	var review internal.Review
+	err := collection.FindOne(
+		Cxt,
+		bson.M{"userId": userID, "restaurantId": restaurantID},
+		options.FindOne().SetSort(bson.D{{Key: "visit", Value: -1}}),
+	).Decode(&review)
+	if err != nil {
+		return review, err
+	}
+
+	return review, nil
+}
+
+// GetRestaurantReviews returns one page of published reviews sorted by sortField
+// ("createdAt" or "rating") and the total number of published reviews.
+func GetRestaurantReviews(restaurantID string, page int64, limit int64, sortField string, order int) ([]internal.Review, int64, error) {
+	collection := getCollection("reviews")
+	filter := bson.M{"restaurantId": restaurantID, "status": internal.ReviewPublished}
+
+	total, err := collection.CountDocuments(Cxt, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count reviews: %s", err)
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: sortField, Value: order}, {Key: "_id", Value: order}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(Cxt, filter, findOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list reviews: %s", err)
+	}
+
+	reviews := []internal.Review{}
+	if err := cursor.All(Cxt, &reviews); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode reviews: %s", err)
+	}
+
+	return reviews, total, nil
+}
+
+// ErrReviewModerated is returned when revising a review a moderator holds, hid or
+// rejected.
+var ErrReviewModerated = errors.New("review is under moderation")
+
+// moderatedStatuses are the states in which only a moderator can change a review.
+var moderatedStatuses = bson.A{internal.ReviewPending, internal.ReviewHidden, internal.ReviewRejected}
+
+// ReviseReview replaces the text of a review and sends it back for scoring. A
+// published review leaves the restaurant aggregate until it is scored again. A
+// review in moderation can't be revised, as scoring the new text would publish it
+// behind the moderator's back.
+func ReviseReview(id string, text string, userRating float64, userAspects map[string]float64) (internal.Review, error) {
+	collection := getCollection("reviews")
+
+	var before internal.Review
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": id, "status": bson.M{"$nin": moderatedStatuses}},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "text", Value: text},
+				{Key: "rating", Value: userRating},
+				{Key: "userRating", Value: userRating},
+				{Key: "userAspects", Value: userAspects},
+				{Key: "status", Value: internal.ReviewPendingAnalysis},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}},
+			{Key: "$unset", Value: bson.D{
+				{Key: "analysisError", Value: ""},
+				{Key: "nlpRating", Value: ""},
+				{Key: "policyVersion", Value: ""},
+				{Key: "aspects", Value: ""},
+				{Key: "uncounted", Value: ""},
+			}},
+		},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		if _, getErr := GetReview(id); getErr == nil {
+			return before, ErrReviewModerated
+		}
+		return before, err
+	}
+	if err != nil {
+		return before, err
+	}
+
+	if counted(before) {
+		err = applyRatingChange(ratingChange{
+			RestaurantID: before.RestaurantID,
+			CreatedAt:    before.CreatedAt,
+			Remove:       true,
+			OldRating:    before.Rating,
+			OldAspects:   before.Aspects,
+		})
+		if err != nil {
+			return before, err
+		}
+	}
+
+	review := before
+	review.Text = text
+	review.Rating = userRating
+	review.UserRating = userRating
+	review.Status = internal.ReviewPendingAnalysis
+	review.Revision = before.Revision + 1
+	review.AnalysisError = ""
+	review.NLPRating = nil
+	review.PolicyVersion = ""
+	review.UserAspects = userAspects
+	review.Aspects = nil
+	review.Uncounted = false
+
+	err = EnqueueNLPJob(review.ID, review.Revision, userRating)
+	if err != nil {
+		// A review without a job would stay pending forever
+		if restoreErr := restoreReview(before); restoreErr != nil {
+			log.Printf("Failed to restore review %s: %v", id, restoreErr)
+		}
+		return review, err
+	}
+
+	return review, nil
+}
+
+// restoreReview puts back a review as it was before a revision that couldn't be
+// queued for scoring, and counts it again if it was published.
+func restoreReview(before internal.Review) error {
+	collection := getCollection("reviews")
+
+	result, err := collection.ReplaceOne(Cxt, bson.M{"_id": before.ID, "revision": before.Revision + 1}, before)
+	if err != nil {
+		return fmt.Errorf("failed to restore review: %s", err)
+	}
+	if result.MatchedCount == 0 || !counted(before) {
+		return nil
+	}
+
+	return applyRatingChange(ratingChange{
+		RestaurantID: before.RestaurantID,
+		CreatedAt:    before.CreatedAt,
+		Add:          true,
+		NewRating:    before.Rating,
+		NewAspects:   before.Aspects,
+	})
+}
+
+// PublishReview stores the score of a review revision and adds it to the restaurant
+// aggregate. The review is published uncounted first, so when the aggregate update
+// fails calling it again, or CountReview, finishes the job instead of skipping the
+// review. It returns false when the review was edited or deleted since the revision
+// was queued, or was already published and counted.
+func PublishReview(id string, revision int, score internal.ReviewScore) (bool, error) {
+	collection := getCollection("reviews")
+
+	result, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPendingAnalysis},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "rating", Value: score.Rating},
+				{Key: "nlpRating", Value: score.NLPRating},
+				{Key: "policyVersion", Value: score.PolicyVersion},
+				{Key: "aspects", Value: score.Aspects},
+				{Key: "status", Value: internal.ReviewPublished},
+				{Key: "uncounted", Value: true},
+			}},
+			{Key: "$unset", Value: bson.D{{Key: "analysisError", Value: ""}}},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to publish review: %s", err)
+	}
+
+	countedNow, err := CountReview(id, revision)
+	if err != nil {
+		return true, err
+	}
+
+	return result.MatchedCount > 0 || countedNow, nil
+}
+
+// CountReview adds a published but uncounted review revision to the restaurant
+// aggregate. The review is claimed before the aggregate changes, so it is counted
+// once however many callers race, and handed back when the update fails. It returns
+// false when there was nothing to count.
+func CountReview(id string, revision int) (bool, error) {
+	collection := getCollection("reviews")
+
+	var review internal.Review
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPublished, "uncounted": true},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "uncounted", Value: ""}}}},
+	).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to count review: %s", err)
+	}
+
+	err = applyRatingChange(ratingChange{
+		RestaurantID: review.RestaurantID,
+		CreatedAt:    review.CreatedAt,
+		Add:          true,
+		NewRating:    review.Rating,
+		NewAspects:   review.Aspects,
+	})
+	if err != nil {
+		_, restoreErr := collection.UpdateOne(
+			Cxt,
+			bson.M{"_id": id, "revision": revision, "status": internal.ReviewPublished, "uncounted": bson.M{"$exists": false}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "uncounted", Value: true}}}},
+		)
+		if restoreErr != nil {
+			log.Printf("Failed to mark review %s uncounted: %v", id, restoreErr)
+		}
+		return true, err
+	}
+
+	return true, nil
+}
+
+// GetPublishedReviews returns up to limit published reviews with IDs after afterID,
+// in ID order, for walking the whole collection.
+func GetPublishedReviews(afterID string, limit int64) ([]internal.Review, error) {
+	collection := getCollection("reviews")
+
+	filter := bson.M{"status": internal.ReviewPublished}
+	if afterID != "" {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	cursor, err := collection.Find(Cxt, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %s", err)
+	}
+
+	reviews := []internal.Review{}
+	if err := cursor.All(Cxt, &reviews); err != nil {
+		return nil, fmt.Errorf("failed to decode reviews: %s", err)
+	}
+
+	return reviews, nil
+}
+
+func CountPublishedReviews() (int64, error) {
+	collection := getCollection("reviews")
+
+	count, err := collection.CountDocuments(Cxt, bson.M{"status": internal.ReviewPublished})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reviews: %s", err)
+	}
+
+	return count, nil
+}
+
+// RescoreReview replaces the rating of a published review revision and moves the
+// restaurant aggregate by the difference. It returns false when the review was
+// edited or deleted in the meantime, or isn't counted yet.
+func RescoreReview(id string, revision int, score internal.ReviewScore) (bool, error) {
+	collection := getCollection("reviews")
+
+	var before internal.Review
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPublished, "uncounted": bson.M{"$ne": true}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "rating", Value: score.Rating},
+			{Key: "nlpRating", Value: score.NLPRating},
+			{Key: "policyVersion", Value: score.PolicyVersion},
+			{Key: "aspects", Value: score.Aspects},
+		}}},
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to rescore review: %s", err)
+	}
+
+	err = applyRatingChange(ratingChange{
+		RestaurantID: before.RestaurantID,
+		CreatedAt:    before.CreatedAt,
+		Remove:       true,
+		OldRating:    before.Rating,
+		OldAspects:   before.Aspects,
+		Add:          true,
+		NewRating:    score.Rating,
+		NewAspects:   score.Aspects,
+	})
+	if err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// RejectReview marks a review revision that can't be scored, e.g. one longer than
+// the NLP service accepts.
+func RejectReview(id string, revision int) (bool, error) {
+	collection := getCollection("reviews")
+
+	result, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPendingAnalysis},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: internal.ReviewRejected}}}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to reject review: %s", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// FailReviewAnalysis marks a review revision whose scoring job ran out of attempts,
+// so it no longer looks queued.
+func FailReviewAnalysis(id string, revision int) error {
+	collection := getCollection("reviews")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPendingAnalysis},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: internal.ReviewAnalysisFailed}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark review analysis failed: %s", err)
+	}
+
+	return nil
+}
+
+// RequeueReview sends a review whose analysis failed back for scoring and returns
+// it as it is now. A review still waiting for analysis gets a new job as well, in
+// case its job was lost.
+func RequeueReview(id string) (internal.Review, error) {
+	collection := getCollection("reviews")
+
+	var review internal.Review
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		bson.M{
+			"_id":    id,
+			"status": bson.M{"$in": bson.A{internal.ReviewAnalysisFailed, internal.ReviewPendingAnalysis}},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "status", Value: internal.ReviewPendingAnalysis}}},
+			{Key: "$unset", Value: bson.D{{Key: "analysisError", Value: ""}}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&review)
+	if err != nil {
+		return review, err
+	}
+
+	err = EnqueueNLPJob(review.ID, review.Revision, review.UserRating)
+	if err != nil {
+		return review, err
+	}
+
+	return review, nil
+}
+
+// SetReviewAnalysisError records why scoring of a pending review revision failed.
+func SetReviewAnalysisError(id string, revision int, kind string) error {
+	collection := getCollection("reviews")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.M{"_id": id, "revision": revision, "status": internal.ReviewPendingAnalysis},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "analysisError", Value: kind}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record analysis error: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteReview removes the review, its analysis, reports and any pending scoring
+// job, and takes a published rating out of the restaurant aggregate.
+func DeleteReview(id string) error {
+	collection := getCollection("reviews")
+
+	var review internal.Review
+	err := collection.FindOneAndDelete(Cxt, bson.M{"_id": id}).Decode(&review)
+	if err != nil {
+		return err
+	}
+
+	if counted(review) {
+		err = applyRatingChange(ratingChange{
+			RestaurantID: review.RestaurantID,
+			CreatedAt:    review.CreatedAt,
+			Remove:       true,
+			OldRating:    review.Rating,
+			OldAspects:   review.Aspects,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = DeleteNLPJob(review.ID)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteReviewFlags(review.ID)
+	if err != nil {
+		return err
+	}
+
+	return DeleteNLPResult(review.ID)
+}
+
+// deleteRestaurantReviews removes every review of a restaurant with its analyses,
+// reports and scoring jobs. The caller drops the restaurant aggregate.
+func deleteRestaurantReviews(restaurantID string) error {
+	collection := getCollection("reviews")
+
+	ids, err := collection.Distinct(Cxt, "_id", bson.M{"restaurantId": restaurantID})
+	if err != nil {
+		return fmt.Errorf("failed to list restaurant reviews: %s", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = collection.DeleteMany(Cxt, bson.M{"restaurantId": restaurantID})
+	if err != nil {
+		return fmt.Errorf("failed to delete restaurant reviews: %s", err)
+	}
+
+	byReview := bson.M{"reviewId": bson.M{"$in": ids}}
+	for _, name := range []string{"nlp_jobs", "nlp_results", "review_flags"} {
+		_, err = getCollection(name).DeleteMany(Cxt, byReview)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s of restaurant reviews: %s", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"restaurant_reviews/internal"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReviseModeratedReview(t *testing.T) {
+	connectTestDB(t)
+
+	for _, status := range []string{internal.ReviewPending, internal.ReviewHidden, internal.ReviewRejected} {
+		id := "moderated-" + status
+		_, err := getCollection("reviews").InsertOne(Cxt, internal.Review{
+			ID:           id,
+			UserID:       "user",
+			RestaurantID: "restaurant",
+			Visit:        1,
+			Text:         "test",
+			Status:       status,
+			Revision:     1,
+			CreatedAt:    time.Now().UTC(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ReviseReview(id, "edited", 5, nil)
+		if err != ErrReviewModerated {
+			t.Errorf("revising a %s review: err = %v, want ErrReviewModerated", status, err)
+		}
+
+		review, err := GetReview(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Status != status || review.Text != "test" || review.Revision != 1 {
+			t.Errorf("%s review was changed to %+v", status, review)
+		}
+	}
+
+	// The moderator's decision must not be undone by a queued scoring job
+	count, err := getCollection("nlp_jobs").CountDocuments(Cxt, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d scoring jobs were queued, want none", count)
+	}
+}
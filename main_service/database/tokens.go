@@ -0,0 +1,116 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"restaurant_reviews/internal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already rotated
+// or revoked is presented again.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+func CreateRefreshToken(token internal.RefreshToken) error {
+	collection := getCollection("refresh_tokens")
+
+	_, err := collection.InsertOne(Cxt, token)
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %s", err)
+	}
+
+	return nil
+}
+
+// UseRefreshToken marks the token as used and returns it. A token can be used only
+// once; later attempts return ErrRefreshTokenReused along with the stored token so
+// the caller can revoke its family.
+func UseRefreshToken(tokenHash string) (internal.RefreshToken, error) {
+	collection := getCollection("refresh_tokens")
+	now := time.Now().UTC()
+
+	var token internal.RefreshToken
+	err := collection.FindOneAndUpdate(
+		Cxt,
+		bson.D{
+			{Key: "tokenHash", Value: tokenHash},
+			{Key: "usedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+			{Key: "revoked", Value: false},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "usedAt", Value: now}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err == nil {
+		return token, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return token, fmt.Errorf("failed to use refresh token: %s", err)
+	}
+
+	err = collection.FindOne(Cxt, bson.D{{Key: "tokenHash", Value: tokenHash}}).Decode(&token)
+	if err != nil {
+		return token, err
+	}
+
+	return token, ErrRefreshTokenReused
+}
+
+func GetRefreshToken(tokenHash string) (internal.RefreshToken, error) {
+	collection := getCollection("refresh_tokens")
+
+	var token internal.RefreshToken
+	err := collection.FindOne(Cxt, bson.D{{Key: "tokenHash", Value: tokenHash}}).Decode(&token)
+	if err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+func RevokeTokenFamily(familyID string) error {
+	collection := getCollection("refresh_tokens")
+
+	_, err := collection.UpdateMany(
+		Cxt,
+		bson.D{{Key: "familyId", Value: familyID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revoked", Value: true}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %s", err)
+	}
+
+	return nil
+}
+
+// RevokeAccessToken blacklists the jti until the token would have expired anyway,
+// after which the TTL index removes the entry.
+func RevokeAccessToken(jti string, expiresAt time.Time) error {
+	collection := getCollection("revoked_tokens")
+
+	_, err := collection.UpdateOne(
+		Cxt,
+		bson.D{{Key: "_id", Value: jti}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: expiresAt}}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %s", err)
+	}
+
+	return nil
+}
+
+func IsAccessTokenRevoked(jti string) (bool, error) {
+	collection := getCollection("revoked_tokens")
+
+	count, err := collection.CountDocuments(Cxt, bson.D{{Key: "_id", Value: jti}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %s", err)
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"restaurant_reviews/internal"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EntryKey is the gin context key the audit middleware stores the admin log entry
+// of the request under.
+const EntryKey = "audit"
+
+func getEntry(c *gin.Context) (*internal.AdminLog, bool) {
+	value, ok := c.Get(EntryKey)
+	if !ok {
+		return nil, false
+	}
+
+	entry, ok := value.(*internal.AdminLog)
+	return entry, ok
+}
+
+// Record names the action a handler took on target and keeps the target as it was
+// before and after as JSON. Either snapshot can be nil, e.g. when creating or
+// deleting. It does nothing outside audited routes.
+func Record(c *gin.Context, actionType string, target string, before interface{}, after interface{}) {
+	entry, ok := getEntry(c)
+	if !ok {
+		return
+	}
+
+	entry.ActionType = actionType
+	entry.Target = target
+	entry.Before = snapshot(before)
+	entry.After = snapshot(after)
+}
+
+// Details adds a free-form explanation to the log entry, like a moderator's note.
+func Details(c *gin.Context, details string) {
+	entry, ok := getEntry(c)
+	if !ok {
+		return
+	}
+
+	entry.Details = details
+}
+
+func snapshot(value interface{}) internal.Snapshot {
+	if value == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to snapshot %T for the audit log: %v", value, err)
+		return ""
+	}
+
+	return internal.Snapshot(data)
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/audit"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mergeCategoryRequest struct {
+	TargetID string `json:"targetId"`
+}
+
+func CreateCategoryHandler(c *gin.Context) {
+	var category internal.Category
+	if err := c.BindJSON(&category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category name is required"})
+		return
+	}
+
+	category.ID = primitive.NewObjectID().Hex()
+
+	result, err := database.CreateCategory(category)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Category already exists"})
+			return
+		}
+		log.Printf("Error creating category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save category"})
+		return
+	}
+
+	audit.Record(c, "create_category", result.ID, nil, result)
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func GetCategoriesHandler(c *gin.Context) {
+	categories, err := database.GetCategories()
+	if err != nil {
+		log.Printf("Error listing categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, categories)
+}
+
+func RenameCategoryHandler(c *gin.Context) {
+	var category internal.Category
+	if err := c.BindJSON(&category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	category.ID = c.Param("id")
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Category name is required"})
+		return
+	}
+
+	before, err := database.GetCategory(category.ID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		log.Printf("Error getting category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename category"})
+		return
+	}
+
+	err = database.RenameCategory(category.ID, category.Name)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Category already exists"})
+			return
+		}
+		log.Printf("Error renaming category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename category"})
+		return
+	}
+
+	audit.Record(c, "rename_category", category.ID, before, category)
+
+	c.JSON(http.StatusOK, category)
+}
+
+func MergeCategoryHandler(c *gin.Context) {
+	var request mergeCategoryRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	sourceID := c.Param("id")
+	if request.TargetID == "" || request.TargetID == sourceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A different target category is required"})
+		return
+	}
+
+	var categories []internal.Category
+	for _, id := range []string{sourceID, request.TargetID} {
+		category, err := database.GetCategory(id)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found", "id": id})
+				return
+			}
+			log.Printf("Error getting category: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge categories"})
+			return
+		}
+		categories = append(categories, category)
+	}
+
+	moved, err := database.MergeCategories(sourceID, request.TargetID)
+	if err != nil {
+		log.Printf("Error merging categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge categories"})
+		return
+	}
+
+	result := gin.H{"merged": sourceID, "into": request.TargetID, "restaurants": moved}
+	audit.Record(c, "merge_category", sourceID, categories, result)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteCategoryHandler refuses to delete a category that still has restaurants
+// unless a reassignTo category is given to move them into. Restaurants added to the
+// category while it is deleted are moved too, or left without a category.
+func DeleteCategoryHandler(c *gin.Context) {
+	id := c.Param("id")
+	reassignTo := c.Query("reassignTo")
+
+	before, err := database.GetCategory(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		log.Printf("Error getting category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	count, err := database.CountCategoryRestaurants(id)
+	if err != nil {
+		log.Printf("Error counting category restaurants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	if count > 0 && reassignTo == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category is in use", "restaurants": count})
+		return
+	}
+	if reassignTo != "" {
+		if reassignTo == id {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A different category is required to reassign restaurants"})
+			return
+		}
+
+		exists, err := database.CategoryExists(reassignTo)
+		if err != nil {
+			log.Printf("Error checking category: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category to reassign restaurants to does not exist"})
+			return
+		}
+	}
+
+	moved, err := database.DeleteCategory(id, reassignTo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		log.Printf("Error deleting category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	audit.Record(c, "delete_category", id, before, nil)
+	if moved > 0 && reassignTo != "" {
+		audit.Details(c, "restaurants reassigned to "+reassignTo)
+	}
+	if moved > 0 && reassignTo == "" {
+		// Restaurants added after the category was checked lose their category
+		log.Printf("Deleted category %s left %d restaurants without a category", id, moved)
+		audit.Details(c, fmt.Sprintf("%d restaurants left without a category", moved))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"Deleted category": id})
+}
+
+func GetCategoryRestaurantsHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	exists, err := database.CategoryExists(id)
+	if err != nil {
+		log.Printf("Error checking category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list restaurants"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	restaurants, err := database.GetCategoryRestaurants(id)
+	if err != nil {
+		log.Printf("Error listing category restaurants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list restaurants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, restaurants)
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/jwtAuth"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxCollectionNameLength = 100
+	maxCollectionNoteLength = 500
+)
+
+type collectionRequest struct {
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+}
+
+type collectionEntryRequest struct {
+	Note string `json:"note"`
+}
+
+type reorderCollectionRequest struct {
+	RestaurantIDs []string `json:"restaurantIds"`
+}
+
+type copyCollectionRequest struct {
+	Name string `json:"name"`
+}
+
+func newShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// validateCollection trims the name and fills in the default visibility. It returns
+// a message for the client when the request is invalid.
+func validateCollection(request *collectionRequest) string {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		return "Collection name is required"
+	}
+	if len(request.Name) > maxCollectionNameLength {
+		return fmt.Sprintf("Collection name must be at most %d characters", maxCollectionNameLength)
+	}
+
+	switch request.Visibility {
+	case "":
+		request.Visibility = internal.CollectionPrivate
+	case internal.CollectionPrivate, internal.CollectionUnlisted, internal.CollectionPublic:
+	default:
+		return "visibility must be private, unlisted or public"
+	}
+
+	return ""
+}
+
+// loadOwnCollection fetches the collection named in the URL if it belongs to the
+// caller. Other users' collections are reported as not found. It writes the error
+// response itself and returns false on failure.
+func loadOwnCollection(c *gin.Context) (internal.Collection, bool) {
+	collection, err := database.GetCollection(c.Param("id"))
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error getting collection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collection"})
+		return collection, false
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+	if err == mongo.ErrNoDocuments || collection.UserID != claims.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return collection, false
+	}
+
+	return collection, true
+}
+
+// writeCollectionError answers a failed change to the caller's collection.
+func writeCollectionError(c *gin.Context, err error, message string) {
+	switch err {
+	case mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+	case database.ErrCollectionExists:
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have a collection with this name"})
+	case database.ErrCollectionFull:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A collection can hold at most %d restaurants", database.MaxCollectionEntries)})
+	case database.ErrCollectionChanged:
+		c.JSON(http.StatusConflict, gin.H{"error": "Collection was changed, reload it and try again"})
+	default:
+		log.Printf("Error changing collection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// withRestaurants replaces the entries of the collection by its restaurants and
+// their ratings. The share token is only kept for the owner.
+func withRestaurants(c *gin.Context, collection internal.Collection, owner bool) {
+	restaurants, err := database.GetCollectionRestaurants(collection)
+	if err != nil {
+		log.Printf("Error listing collection restaurants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collection"})
+		return
+	}
+
+	collection.Entries = nil
+	if !owner {
+		collection.ShareToken = ""
+	}
+
+	c.JSON(http.StatusOK, internal.CollectionWithRestaurants{
+		Collection:  collection,
+		Restaurants: restaurants,
+	})
+}
+
+func GetUserCollectionsHandler(c *gin.Context) {
+	claims, _ := jwtAuth.GetClaims(c)
+
+	collections, err := database.GetUserCollections(claims.UserID)
+	if err != nil {
+		log.Printf("Error listing collections: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, collections)
+}
+
+func CreateCollectionHandler(c *gin.Context) {
+	var request collectionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if message := validateCollection(&request); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		log.Printf("Error creating share token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save collection"})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+	now := time.Now().UTC()
+
+	result, err := database.CreateCollection(internal.Collection{
+		ID:         primitive.NewObjectID().Hex(),
+		UserID:     claims.UserID,
+		Name:       request.Name,
+		Visibility: request.Visibility,
+		ShareToken: token,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		writeCollectionError(c, err, "Failed to save collection")
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func GetUserCollectionHandler(c *gin.Context) {
+	collection, ok := loadOwnCollection(c)
+	if !ok {
+		return
+	}
+
+	withRestaurants(c, collection, true)
+}
+
+func UpdateCollectionHandler(c *gin.Context) {
+	var request collectionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if message := validateCollection(&request); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+
+	result, err := database.UpdateCollection(c.Param("id"), claims.UserID, request.Name, request.Visibility)
+	if err != nil {
+		writeCollectionError(c, err, "Failed to update collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func DeleteCollectionHandler(c *gin.Context) {
+	id := c.Param("id")
+	claims, _ := jwtAuth.GetClaims(c)
+
+	err := database.DeleteCollection(id, claims.UserID)
+	if err != nil {
+		writeCollectionError(c, err, "Failed to delete collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"Deleted collection": id})
+}
+
+// ShareCollectionHandler gives the collection a new share token, so links with the
+// old one stop working.
+func ShareCollectionHandler(c *gin.Context) {
+	token, err := newShareToken()
+	if err != nil {
+		log.Printf("Error creating share token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share collection"})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+
+	result, err := database.SetCollectionShareToken(c.Param("id"), claims.UserID, token)
+	if err != nil {
+		writeCollectionError(c, err, "Failed to share collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SetCollectionEntryHandler adds a restaurant to the end of a collection, or updates
+// its note when it is already in it.
+func SetCollectionEntryHandler(c *gin.Context) {
+	var request collectionEntryRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if len(request.Note) > maxCollectionNoteLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Note must be at most %d characters", maxCollectionNoteLength)})
+		return
+	}
+
+	restaurantID := c.Param("restaurantId")
+	_, err := database.GetRestaurant(restaurantID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update collection"})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+
+	result, err := database.SetCollectionEntry(c.Param("id"), claims.UserID, restaurantID, request.Note)
+	if err != nil {
+		writeCollectionError(c, err, "Failed to update collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func RemoveCollectionEntryHandler(c *gin.Context) {
+	claims, _ := jwtAuth.GetClaims(c)
+
+	result, err := database.RemoveCollectionEntry(c.Param("id"), claims.UserID, c.Param("restaurantId"))
+	if err != nil {
+		writeCollectionError(c, err, "Failed to update collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ReorderCollectionHandler puts the restaurants of a collection in the given order,
+// which must list each of them exactly once.
+func ReorderCollectionHandler(c *gin.Context) {
+	collection, ok := loadOwnCollection(c)
+	if !ok {
+		return
+	}
+
+	var request reorderCollectionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	entries := map[string]internal.CollectionEntry{}
+	for _, entry := range collection.Entries {
+		entries[entry.RestaurantID] = entry
+	}
+
+	reordered := make([]internal.CollectionEntry, 0, len(request.RestaurantIDs))
+	for _, id := range request.RestaurantIDs {
+		entry, ok := entries[id]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "restaurantIds must list every restaurant of the collection once"})
+			return
+		}
+		delete(entries, id)
+		reordered = append(reordered, entry)
+	}
+	if len(entries) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "restaurantIds must list every restaurant of the collection once"})
+		return
+	}
+
+	result, err := database.ReorderCollection(collection, reordered)
+	if err != nil {
+		writeCollectionError(c, err, "Failed to reorder collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetPublicCollectionsHandler lists public collections, most recently updated first.
+func GetPublicCollectionsHandler(c *gin.Context) {
+	page, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	collections, total, err := database.GetPublicCollections(page, limit)
+	if err != nil {
+		log.Printf("Error listing public collections: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections"})
+		return
+	}
+
+	for i := range collections {
+		collections[i].ShareToken = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collections": collections,
+		"page":        page,
+		"limit":       limit,
+		"total":       total,
+	})
+}
+
+// GetSharedCollectionHandler opens an unlisted or public collection by its share
+// token, without login.
+func GetSharedCollectionHandler(c *gin.Context) {
+	collection, err := database.GetSharedCollection(c.Param("token"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+			return
+		}
+		log.Printf("Error getting shared collection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get collection"})
+		return
+	}
+
+	withRestaurants(c, collection, false)
+}
+
+// CopyCollectionHandler copies a public collection, with its order and notes, into
+// a new private collection of the caller. The copy keeps the name unless another
+// one is given.
+func CopyCollectionHandler(c *gin.Context) {
+	var request copyCollectionRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+
+	source, err := database.GetCollection(c.Param("id"))
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error getting collection: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy collection"})
+		return
+	}
+	if err == mongo.ErrNoDocuments || (source.Visibility != internal.CollectionPublic && source.UserID != claims.UserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+
+	copyRequest := collectionRequest{Name: source.Name, Visibility: internal.CollectionPrivate}
+	if request.Name != "" {
+		copyRequest.Name = request.Name
+	}
+	if message := validateCollection(&copyRequest); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		log.Printf("Error creating share token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy collection"})
+		return
+	}
+
+	now := time.Now().UTC()
+	entries := make([]internal.CollectionEntry, len(source.Entries))
+	for i, entry := range source.Entries {
+		entries[i] = internal.CollectionEntry{RestaurantID: entry.RestaurantID, Note: entry.Note, AddedAt: now}
+	}
+
+	result, err := database.CreateCollection(internal.Collection{
+		ID:         primitive.NewObjectID().Hex(),
+		UserID:     claims.UserID,
+		Name:       copyRequest.Name,
+		Visibility: copyRequest.Visibility,
+		ShareToken: token,
+		Entries:    entries,
+		CopiedFrom: source.ID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		writeCollectionError(c, err, "Failed to copy collection")
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
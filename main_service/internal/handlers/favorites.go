@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal/jwtAuth"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AddFavoriteHandler adds a restaurant to the user's favorites. Adding it again
+// succeeds without changing anything.
+func AddFavoriteHandler(c *gin.Context) {
+	restaurantID := c.Param("restaurantId")
+
+	_, err := database.GetRestaurant(restaurantID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add favorite"})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+
+	added, err := database.AddFavorite(claims.UserID, restaurantID)
+	if err != nil {
+		log.Printf("Error adding favorite: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add favorite"})
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"Favorite restaurant": restaurantID})
+}
+
+// RemoveFavoriteHandler removes a restaurant from the user's favorites. Removing one
+// that isn't there succeeds as well.
+func RemoveFavoriteHandler(c *gin.Context) {
+	restaurantID := c.Param("restaurantId")
+	claims, _ := jwtAuth.GetClaims(c)
+
+	err := database.RemoveFavorite(claims.UserID, restaurantID)
+	if err != nil {
+		log.Printf("Error removing favorite: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove favorite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"Removed favorite": restaurantID})
+}
+
+func GetFavoritesHandler(c *gin.Context) {
+	claims, _ := jwtAuth.GetClaims(c)
+
+	favorites, err := database.GetFavorites(claims.UserID)
+	if err != nil {
+		log.Printf("Error listing favorites: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list favorites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, favorites)
+}
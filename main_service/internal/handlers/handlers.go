@@ -6,25 +6,47 @@ import (
 	"net/http"
 	"restaurant_reviews/database"
 	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/audit"
 	"restaurant_reviews/internal/jwtAuth"
 	"restaurant_reviews/internal/nlp"
-	"strconv"
+	"restaurant_reviews/internal/password"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func RegisterHandler(c *gin.Context) {
 	var user internal.User
 	err := c.BindJSON(&user)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if user.Email == "" || user.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
 		return
 	}
 
-	insertResult, err := database.RegisterUser(user.Email, user.Password, user.Role)
+	passwordHash, err := password.Hash(user.Password)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Printf("Error hashing password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
+
+	// Admins are promoted in the database, never through self-registration
+	insertResult, err := database.RegisterUser(user.Email, passwordHash, "user")
+	if err != nil {
+		if err == database.ErrEmailTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+			return
+		}
+		log.Printf("Error registering user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"InsertID": insertResult})
@@ -39,18 +61,72 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	user, err = database.GetUser(user.Email, user.Password)
+	plain := user.Password
+
+	user, err = database.GetUserByEmail(user.Email)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	match, needsRehash, err := password.Verify(plain, user.Password)
+	if err != nil {
+		log.Printf("Error verifying password for %s: %v", user.Email, err)
+	}
+	if !match {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	// Upgrade plaintext or outdated hashes now that we know the password
+	if needsRehash {
+		passwordHash, err := password.Hash(plain)
+		if err == nil {
+			err = database.UpdatePasswordHash(user.Email, passwordHash)
+		}
+		if err != nil {
+			log.Printf("Error rehashing password for %s: %v", user.Email, err)
+		}
+	}
+
+	familyID := primitive.NewObjectID().Hex()
+	issueTokens(c, user, familyID)
+}
+
+// issueTokens hands out a new access token and a rotated refresh token belonging
+// to the given family, both as cookies and in the response body.
+func issueTokens(c *gin.Context, user internal.User, familyID string) {
+	tokenString, err := jwtAuth.CreateToken(user.ID, user.Email, user.Role)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	tokenString, err := jwtAuth.CreateToken(user.Email, user.Role)
+	refreshToken, refreshHash, err := jwtAuth.CreateRefreshToken()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	now := time.Now().UTC()
+	err = database.CreateRefreshToken(internal.RefreshToken{
+		ID:        primitive.NewObjectID().Hex(),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(jwtAuth.RefreshTTL()),
+	})
+	if err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue tokens"})
+		return
+	}
+
 	http.SetCookie(c.Writer, &http.Cookie{
 		Name:     "jwt",
 		Value:    tokenString,
@@ -58,22 +134,126 @@ func LoginHandler(c *gin.Context) {
 		HttpOnly: true,
 		Secure:   false,
 		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  now.Add(jwtAuth.AccessTTL()),
 	})
 
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     "/user",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  now.Add(jwtAuth.RefreshTTL()),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"token": tokenString, "refreshToken": refreshToken})
 }
 
-func GetUserHandler(c *gin.Context) {
-	email, role, err := jwtAuth.GetJWTDataFromCookie(c)
+// refreshTokenFromRequest reads the refresh token from its cookie, falling back to
+// a JSON body for clients that don't keep cookies.
+func refreshTokenFromRequest(c *gin.Context) string {
+	if cookie, err := c.Request.Cookie("refresh_token"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		return ""
+	}
+
+	return body.RefreshToken
+}
+
+func clearAuthCookies(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{Name: "jwt", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.SetCookie(c.Writer, &http.Cookie{Name: "refresh_token", Path: "/user", MaxAge: -1, HttpOnly: true})
+}
+
+func RefreshHandler(c *gin.Context) {
+	refreshToken := refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token required"})
+		return
+	}
+
+	stored, err := database.UseRefreshToken(jwtAuth.HashRefreshToken(refreshToken))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err == database.ErrRefreshTokenReused {
+			// Someone replayed a rotated token, so every token derived from the same
+			// login is considered compromised.
+			log.Printf("Refresh token reuse detected for user %s, revoking family %s", stored.UserID, stored.FamilyID)
+			if err := database.RevokeTokenFamily(stored.FamilyID); err != nil {
+				log.Printf("Error revoking token family: %v", err)
+			}
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected"})
+			return
+		}
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		log.Printf("Error using refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"email": email, "role": role})
+	if time.Now().After(stored.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	user, err := database.GetUserByID(stored.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	issueTokens(c, user, stored.FamilyID)
+}
+
+func LogoutHandler(c *gin.Context) {
+	claims, _ := jwtAuth.GetClaims(c)
+
+	err := database.RevokeAccessToken(claims.ID, claims.ExpiresAt)
+	if err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	// Only the caller's own sessions can be ended, not one whose token they got hold of
+	if refreshToken := refreshTokenFromRequest(c); refreshToken != "" {
+		stored, err := database.GetRefreshToken(jwtAuth.HashRefreshToken(refreshToken))
+		if err == nil && stored.UserID == claims.UserID {
+			err = database.RevokeTokenFamily(stored.FamilyID)
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("Error revoking refresh tokens: %v", err)
+		}
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"status": "logged out"})
+}
+
+// JWKSHandler publishes the public keys other services use to verify our tokens.
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwtAuth.JWKS())
 }
 
+func GetUserHandler(c *gin.Context) {
+	claims, _ := jwtAuth.GetClaims(c)
+
+	c.JSON(http.StatusOK, gin.H{"id": claims.UserID, "email": claims.Email, "role": claims.Role})
+}
+
+// FeedBackHandler saves a review by the signed in user. What happens when they
+// already reviewed the restaurant depends on the review policy.
 func FeedBackHandler(c *gin.Context) {
 	var review internal.Review
 
@@ -83,78 +263,121 @@ func FeedBackHandler(c *gin.Context) {
 	}
 
 	// Validate required fields
+	if review.RestaurantID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Restaurant id is required"})
+		return
+	}
 	if review.Text == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Review text is required"})
 		return
 	}
+	if nlp.CheckLength(review.Text) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Review text must be at most %d characters", nlp.MaxTextLength())})
+		return
+	}
 	if review.Rating < 0 || review.Rating > 5 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 0 and 5"})
 		return
 	}
-
-	nlpReview := nlp.CheckMessage(review.Text)
-	if !nlpReview.Status {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "NLP service error",
-			"details": nlpReview.TextReview,
-		})
+	if message := validateAspects(review.Aspects); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
 		return
 	}
 
-	review.CreatedAt = time.Now().UTC()
-
-	if review.ID == "" {
-		review.ID = primitive.NewObjectID().Hex()
+	_, err := database.GetRestaurant(review.RestaurantID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return
 	}
 
-	mixRating := (review.Rating * 0.7) + (nlpReview.Rating * 0.3)
+	claims, _ := jwtAuth.GetClaims(c)
 
-	if mixRating < 0 {
-		mixRating = 0
-	} else if mixRating > 5 {
-		mixRating = 5
+	latest, err := database.GetLatestUserReview(claims.UserID, review.RestaurantID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error getting previous review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return
 	}
+	reviewed := err == nil
 
-	result, err := database.CreateFeedBack(review.ID, review.UserID, review.RestaurantID, review.Text, mixRating)
+	// The review is scored in the background and counted once it is published
+	var result internal.Review
+	switch {
+	case reviewed && reviewMode == ReviewModeSingle && isModerated(latest):
+		c.JSON(http.StatusConflict, gin.H{"error": "Your review of this restaurant is under moderation and can't be edited"})
+		return
+	case reviewed && reviewMode == ReviewModeSingle:
+		result, err = database.ReviseReview(latest.ID, review.Text, review.Rating, review.Aspects)
+	case reviewed:
+		if wait := time.Until(latest.CreatedAt.Add(reviewCooldown)); wait > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("You can review this restaurant again in %s", wait.Round(time.Minute))})
+			return
+		}
+		result, err = database.CreateFeedBack(primitive.NewObjectID().Hex(), claims.UserID, review.RestaurantID, latest.Visit+1, review.Text, review.Rating, review.Aspects)
+	default:
+		result, err = database.CreateFeedBack(primitive.NewObjectID().Hex(), claims.UserID, review.RestaurantID, 1, review.Text, review.Rating, review.Aspects)
+	}
 	if err != nil {
+		if err == database.ErrDuplicateReview {
+			c.JSON(http.StatusConflict, gin.H{"error": "You have already reviewed this restaurant"})
+			return
+		}
+		if err == database.ErrReviewModerated {
+			c.JSON(http.StatusConflict, gin.H{"error": "Your review of this restaurant is under moderation and can't be edited"})
+			return
+		}
 		log.Printf("Error creating feedback: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusAccepted, gin.H{
 		"review":     result.ID,
+		"visit":      result.Visit,
 		"rating":     result.Rating,
+		"aspects":    result.UserAspects,
 		"text":       result.Text,
+		"status":     result.Status,
 		"created_at": result.CreatedAt,
 	})
 }
 
 func DeleteUserHandler(c *gin.Context) {
-	_, role, err := jwtAuth.GetJWTDataFromCookie(c)
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
 		return
 	}
 
-	id := c.Param("id")
-	objID, err := primitive.ObjectIDFromHex(id)
+	user, err := database.GetUserByID(id)
 	if err != nil {
-		fmt.Println("Invalid ObjectID:", err)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
 
-	numId, _ := strconv.Atoi(objID.Hex())
-
-	if role == "admin" {
-		_, err := database.DeleteUser(numId)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	err = database.DeleteUser(objID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"Deleted user": id})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
-
 	}
+
+	user.Password = ""
+	audit.Record(c, "delete_user", id, user, nil)
+
+	c.JSON(http.StatusOK, gin.H{"Deleted user": id})
 }
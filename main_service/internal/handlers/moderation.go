@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/audit"
+	"restaurant_reviews/internal/jwtAuth"
+	"restaurant_reviews/internal/scoring"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxFlagReasonLength = 500
+
+// moderationActions maps the actions of ModerateReviewHandler to review states.
+var moderationActions = map[string]string{
+	"approve": internal.ReviewPublished,
+	"reject":  internal.ReviewRejected,
+	"hide":    internal.ReviewHidden,
+}
+
+type flagReviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+type moderateReviewRequest struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+// FlagReviewHandler reports a published review to the moderators.
+func FlagReviewHandler(c *gin.Context) {
+	var request flagReviewRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if request.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required"})
+		return
+	}
+	if len(request.Reason) > maxFlagReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Reason must be at most %d characters", maxFlagReasonLength)})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+
+	review, err := database.GetReview(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		log.Printf("Error getting review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flag review"})
+		return
+	}
+	if review.UserID == claims.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't flag your own review"})
+		return
+	}
+
+	review, err = database.FlagReview(internal.ReviewFlag{
+		ID:        primitive.NewObjectID().Hex(),
+		ReviewID:  review.ID,
+		UserID:    claims.UserID,
+		Reason:    request.Reason,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if err == database.ErrAlreadyFlagged {
+			c.JSON(http.StatusConflict, gin.H{"error": "You have already flagged this review"})
+			return
+		}
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		log.Printf("Error flagging review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flag review"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"Flagged review": review.ID})
+}
+
+// GetModerationQueueHandler lists the reviews waiting for a moderator or for a
+// requeue after their analysis failed, and the published reviews users reported,
+// with the reports.
+func GetModerationQueueHandler(c *gin.Context) {
+	page, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	items, total, err := database.GetModerationQueue(page, limit)
+	if err != nil {
+		log.Printf("Error listing moderation queue: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list moderation queue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": items,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	})
+}
+
+// ModerateReviewHandler approves, rejects or hides a review and records the
+// decision and the moderator's note in the admin log. Approving a review the NLP
+// service refused publishes it with the user's own rating.
+func ModerateReviewHandler(c *gin.Context) {
+	var request moderateReviewRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	status, ok := moderationActions[request.Action]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be approve, reject or hide"})
+		return
+	}
+
+	review, err := database.GetReview(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		log.Printf("Error getting review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate review"})
+		return
+	}
+	if review.Status == internal.ReviewPendingAnalysis {
+		c.JSON(http.StatusConflict, gin.H{"error": "Review is still being analyzed"})
+		return
+	}
+
+	var score *internal.ReviewScore
+	if status == internal.ReviewPublished && review.PolicyVersion == "" {
+		userScore := scoring.UserScore(review)
+		score = &userScore
+	}
+
+	before, err := database.ModerateReview(review.ID, review.Revision, status, score)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusConflict, gin.H{"error": "Review was changed, reload it and try again"})
+			return
+		}
+		log.Printf("Error moderating review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate review"})
+		return
+	}
+
+	after := before
+	after.Status = status
+	after.Flags = 0
+	after.Moderation = ""
+	if score != nil {
+		after.Rating = score.Rating
+		after.NLPRating = nil
+		after.PolicyVersion = score.PolicyVersion
+		after.Aspects = score.Aspects
+	}
+	audit.Record(c, request.Action+"_review", review.ID, before, after)
+	audit.Details(c, request.Note)
+
+	c.JSON(http.StatusOK, gin.H{"review": review.ID, "status": status})
+}
+
+// RequeueReviewHandler sends a review whose analysis failed back to the scoring
+// queue, e.g. after the NLP service came back.
+func RequeueReviewHandler(c *gin.Context) {
+	review, err := database.GetReview(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		log.Printf("Error getting review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue review"})
+		return
+	}
+	if review.Status != internal.ReviewAnalysisFailed && review.Status != internal.ReviewPendingAnalysis {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only reviews waiting for analysis can be requeued"})
+		return
+	}
+
+	after, err := database.RequeueReview(review.ID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusConflict, gin.H{"error": "Review was changed, reload it and try again"})
+			return
+		}
+		log.Printf("Error requeueing review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue review"})
+		return
+	}
+	audit.Record(c, "requeue_review", review.ID, review, after)
+
+	c.JSON(http.StatusAccepted, gin.H{"review": review.ID, "status": after.Status})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/audit"
+	"restaurant_reviews/internal/jwtAuth"
+	"restaurant_reviews/internal/scoring"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RescoreReviewsHandler starts rescoring all published reviews in the background and
+// returns the job, whose progress can be followed with GetRescoreJobHandler. With
+// ?mode=policy the NLP service isn't called and only the rating policy is reapplied.
+func RescoreReviewsHandler(c *gin.Context) {
+	mode := c.DefaultQuery("mode", internal.RescoreNLP)
+	if mode != internal.RescoreNLP && mode != internal.RescorePolicy {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be nlp or policy"})
+		return
+	}
+
+	total, err := database.CountPublishedReviews()
+	if err != nil {
+		log.Printf("Error counting reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start rescore"})
+		return
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+	job := internal.RescoreJob{
+		ID:        primitive.NewObjectID().Hex(),
+		AdminID:   claims.UserID,
+		Mode:      mode,
+		Status:    internal.RescoreRunning,
+		Total:     total,
+		StartedAt: time.Now().UTC(),
+	}
+
+	job, err = scoring.StartRescore(job)
+	if err != nil {
+		if err == database.ErrRescoreRunning {
+			c.JSON(http.StatusConflict, gin.H{"error": "A rescore job is already running"})
+			return
+		}
+		log.Printf("Error creating rescore job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start rescore"})
+		return
+	}
+
+	audit.Record(c, "rescore_reviews", job.ID, nil, job)
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func GetRescoreJobHandler(c *gin.Context) {
+	job, err := database.GetRescoreJob(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rescore job not found"})
+			return
+		}
+		log.Printf("Error getting rescore job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rescore job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
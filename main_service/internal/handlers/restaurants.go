@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/audit"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultNearbyRadius = 5000.0
+	maxNearbyRadius     = 50000.0
+
+	defaultTopLimit = 10
+	maxTopLimit     = 50
+
+	defaultStatsMonths = 12
+	maxStatsMonths     = 120
+)
+
+// validateRestaurant checks the request fields and returns a message for the client
+// when the restaurant can't be stored.
+func validateRestaurant(restaurant internal.Restaurant) (string, error) {
+	if strings.TrimSpace(restaurant.Name) == "" {
+		return "Restaurant name is required", nil
+	}
+	if restaurant.Location.Type != "Point" {
+		return "Location must be a GeoJSON Point", nil
+	}
+	if len(restaurant.Location.Coordinates) != 2 {
+		return "Location coordinates must be [longitude, latitude]", nil
+	}
+	if longitude := restaurant.Location.Coordinates[0]; longitude < -180 || longitude > 180 {
+		return "Longitude must be between -180 and 180", nil
+	}
+	if latitude := restaurant.Location.Coordinates[1]; latitude < -90 || latitude > 90 {
+		return "Latitude must be between -90 and 90", nil
+	}
+	if restaurant.CategoryID == "" {
+		return "Category is required", nil
+	}
+
+	exists, err := database.CategoryExists(restaurant.CategoryID)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "Category does not exist", nil
+	}
+
+	return "", nil
+}
+
+func CreateRestaurantHandler(c *gin.Context) {
+	var restaurant internal.Restaurant
+	if err := c.BindJSON(&restaurant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if restaurant.Location.Type == "" {
+		restaurant.Location.Type = "Point"
+	}
+
+	message, err := validateRestaurant(restaurant)
+	if err != nil {
+		log.Printf("Error validating restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate restaurant"})
+		return
+	}
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	restaurant.ID = primitive.NewObjectID().Hex()
+
+	result, err := database.CreateRestaurant(restaurant)
+	if err != nil {
+		log.Printf("Error creating restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save restaurant"})
+		return
+	}
+
+	audit.Record(c, "create_restaurant", result.ID, nil, result)
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// parseFinite parses a query parameter as a number. ParseFloat also accepts NaN,
+// which compares false against any bound and so slips past range checks, and Inf.
+func parseFinite(value string) (float64, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("%q is not a finite number", value)
+	}
+
+	return number, nil
+}
+
+// GetRestaurantsHandler lists the restaurants a page at a time. Query parameters
+// such as minFood=4 keep only restaurants whose average for that aspect is at least
+// the given score.
+func GetRestaurantsHandler(c *gin.Context) {
+	page, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	minAspects := map[string]float64{}
+	for _, aspect := range internal.Aspects {
+		param := "min" + strings.ToUpper(aspect[:1]) + aspect[1:]
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		score, err := parseFinite(value)
+		if err != nil || score < 0 || score > 5 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a number between 0 and 5", param)})
+			return
+		}
+		minAspects[aspect] = score
+	}
+
+	restaurants, total, err := database.GetRestaurants(minAspects, page, limit)
+	if err != nil {
+		log.Printf("Error listing restaurants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list restaurants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"restaurants": restaurants,
+		"page":        page,
+		"limit":       limit,
+		"total":       total,
+	})
+}
+
+// GetNearbyRestaurantsHandler returns restaurants within radius metres of the given
+// point, closest first.
+func GetNearbyRestaurantsHandler(c *gin.Context) {
+	latitude, err := parseFinite(c.Query("lat"))
+	if err != nil || latitude < -90 || latitude > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat must be a number between -90 and 90"})
+		return
+	}
+
+	longitude, err := parseFinite(c.Query("lng"))
+	if err != nil || longitude < -180 || longitude > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lng must be a number between -180 and 180"})
+		return
+	}
+
+	radius := defaultNearbyRadius
+	if value := c.Query("radius"); value != "" {
+		radius, err = parseFinite(value)
+		if err != nil || radius <= 0 || radius > maxNearbyRadius {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("radius must be between 0 and %.0f metres", maxNearbyRadius)})
+			return
+		}
+	}
+
+	restaurants, err := database.GetNearbyRestaurants(internal.NewLocation(latitude, longitude, ""), radius)
+	if err != nil {
+		log.Printf("Error searching nearby restaurants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search restaurants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, restaurants)
+}
+
+// GetTopRestaurantsHandler returns the best ranked restaurants, optionally of one
+// category. Ranking favours many and recent reviews over a few good old ones.
+func GetTopRestaurantsHandler(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultTopLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > maxTopLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxTopLimit)})
+		return
+	}
+
+	categoryID := c.Query("category")
+	if categoryID != "" {
+		exists, err := database.CategoryExists(categoryID)
+		if err != nil {
+			log.Printf("Error checking category: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rank restaurants"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	restaurants, err := database.GetTopRestaurants(categoryID, limit)
+	if err != nil {
+		log.Printf("Error ranking restaurants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rank restaurants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, restaurants)
+}
+
+func GetRestaurantHandler(c *gin.Context) {
+	restaurant, err := database.GetRestaurant(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, restaurant)
+}
+
+// GetRestaurantStatsHandler returns how many reviews the restaurant has per star and
+// its review count and average for each of the last months, oldest first.
+func GetRestaurantStatsHandler(c *gin.Context) {
+	months, err := strconv.Atoi(c.DefaultQuery("months", strconv.Itoa(defaultStatsMonths)))
+	if err != nil || months < 1 || months > maxStatsMonths {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("months must be between 1 and %d", maxStatsMonths)})
+		return
+	}
+
+	id := c.Param("id")
+	_, err = database.GetRestaurant(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant stats"})
+		return
+	}
+
+	// A restaurant without reviews has no aggregate yet
+	rating, err := database.GetRating(id)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error getting rating: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get restaurant stats"})
+		return
+	}
+
+	stats := internal.RestaurantStats{
+		RestaurantID:  id,
+		ReviewCount:   rating.ReviewCount,
+		AverageRating: rating.AverageRating,
+		Distribution:  make([]internal.StarCount, 5),
+		Trend:         make([]internal.MonthlyTrend, months),
+	}
+
+	for i := range stats.Distribution {
+		stats.Distribution[i].Stars = i + 1
+		if i < len(rating.Histogram) {
+			stats.Distribution[i].Count = rating.Histogram[i]
+		}
+	}
+
+	now := time.Now().UTC()
+	firstMonth := time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.UTC)
+	for i := range stats.Trend {
+		month := firstMonth.AddDate(0, i, 0).Format("2006-01")
+		stats.Trend[i].Month = month
+
+		if bucket, ok := rating.Monthly[month]; ok && bucket.Count > 0 {
+			average := bucket.Sum / float64(bucket.Count)
+			stats.Trend[i].Count = bucket.Count
+			stats.Trend[i].AverageRating = &average
+		}
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+func UpdateRestaurantHandler(c *gin.Context) {
+	var restaurant internal.Restaurant
+	if err := c.BindJSON(&restaurant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if restaurant.Location.Type == "" {
+		restaurant.Location.Type = "Point"
+	}
+
+	message, err := validateRestaurant(restaurant)
+	if err != nil {
+		log.Printf("Error validating restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate restaurant"})
+		return
+	}
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	restaurant.ID = c.Param("id")
+
+	before, err := database.GetRestaurant(restaurant.ID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update restaurant"})
+		return
+	}
+
+	result, err := database.UpdateRestaurant(restaurant)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error updating restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update restaurant"})
+		return
+	}
+
+	audit.Record(c, "update_restaurant", result.ID, before, result)
+
+	c.JSON(http.StatusOK, result)
+}
+
+func DeleteRestaurantHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	before, err := database.GetRestaurant(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error getting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete restaurant"})
+		return
+	}
+
+	err = database.DeleteRestaurant(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+			return
+		}
+		log.Printf("Error deleting restaurant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete restaurant"})
+		return
+	}
+
+	audit.Record(c, "delete_restaurant", id, before, nil)
+
+	c.JSON(http.StatusOK, gin.H{"Deleted restaurant": id})
+}
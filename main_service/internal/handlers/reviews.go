@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/jwtAuth"
+	"restaurant_reviews/internal/nlp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultReviewsLimit = 20
+	maxReviewsLimit     = 100
+	// maxPage keeps lists from being paged so deep that skipping to the page makes
+	// the database walk millions of documents.
+	maxPage = 10000
+)
+
+// parsePage reads the page and limit query parameters of a paginated list. It
+// answers 400 and returns false when either is out of range.
+func parsePage(c *gin.Context) (int64, int64, bool) {
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 || page > maxPage {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page must be between 1 and %d", maxPage)})
+		return 0, 0, false
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultReviewsLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > maxReviewsLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxReviewsLimit)})
+		return 0, 0, false
+	}
+
+	return page, limit, true
+}
+
+// Review modes, see SetReviewPolicy.
+const (
+	ReviewModeSingle   = "single"
+	ReviewModePerVisit = "per_visit"
+)
+
+var (
+	reviewMode     = ReviewModeSingle
+	reviewCooldown = 30 * 24 * time.Hour
+)
+
+// SetReviewPolicy decides what a user's further reviews of a restaurant do. In
+// single mode they revise the existing review, in per_visit mode they are kept as
+// new visits once cooldown has passed since the previous one.
+func SetReviewPolicy(mode string, cooldown time.Duration) {
+	reviewMode = mode
+	reviewCooldown = cooldown
+}
+
+type updateReviewRequest struct {
+	Text    string             `json:"text"`
+	Rating  float64            `json:"rating"`
+	Aspects map[string]float64 `json:"aspects"`
+}
+
+// validateAspects returns a message for the client when the aspect scores of a
+// review are invalid.
+func validateAspects(aspects map[string]float64) string {
+	for aspect, score := range aspects {
+		if !internal.IsAspect(aspect) {
+			return fmt.Sprintf("Unknown aspect %q, must be one of %s", aspect, strings.Join(internal.Aspects, ", "))
+		}
+		if score < 0 || score > 5 {
+			return "Aspect scores must be between 0 and 5"
+		}
+	}
+
+	return ""
+}
+
+// loadOwnReview fetches the review named in the URL and checks that the caller is
+// its author or an admin. It writes the error response itself and returns false on failure.
+func loadOwnReview(c *gin.Context) (internal.Review, bool) {
+	review, err := database.GetReview(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return review, false
+		}
+		log.Printf("Error getting review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review"})
+		return review, false
+	}
+
+	claims, _ := jwtAuth.GetClaims(c)
+	if claims.UserID != review.UserID && claims.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author or an admin can change this review"})
+		return review, false
+	}
+
+	return review, true
+}
+
+// isModerated tells whether a moderator holds, hid or rejected the review. Its
+// author can't revise it then, as scoring the new text would publish it again.
+func isModerated(review internal.Review) bool {
+	switch review.Status {
+	case internal.ReviewPending, internal.ReviewHidden, internal.ReviewRejected:
+		return true
+	}
+	return false
+}
+
+func GetRestaurantReviewsHandler(c *gin.Context) {
+	page, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	sortFields := map[string]string{"date": "createdAt", "rating": "rating"}
+	sortField, ok := sortFields[c.DefaultQuery("sort", "date")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be date or rating"})
+		return
+	}
+
+	order := -1
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		order = 1
+	case "desc":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+
+	reviews, total, err := database.GetRestaurantReviews(c.Param("id"), page, limit, sortField, order)
+	if err != nil {
+		log.Printf("Error listing reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list reviews"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": reviews,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	})
+}
+
+func UpdateReviewHandler(c *gin.Context) {
+	review, ok := loadOwnReview(c)
+	if !ok {
+		return
+	}
+
+	if isModerated(review) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Review is under moderation and can't be edited"})
+		return
+	}
+
+	var request updateReviewRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if request.Text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Review text is required"})
+		return
+	}
+	if nlp.CheckLength(request.Text) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Review text must be at most %d characters", nlp.MaxTextLength())})
+		return
+	}
+	if request.Rating < 0 || request.Rating > 5 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 0 and 5"})
+		return
+	}
+	if message := validateAspects(request.Aspects); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	result, err := database.ReviseReview(review.ID, request.Text, request.Rating, request.Aspects)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		if err == database.ErrReviewModerated {
+			c.JSON(http.StatusConflict, gin.H{"error": "Review is under moderation and can't be edited"})
+			return
+		}
+		log.Printf("Error updating review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update review"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+func DeleteReviewHandler(c *gin.Context) {
+	review, ok := loadOwnReview(c)
+	if !ok {
+		return
+	}
+
+	err := database.DeleteReview(review.ID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		log.Printf("Error deleting review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"Deleted review": review.ID})
+}
+
+// GetReviewAnalysisHandler returns the NLP analysis of a published review. Until
+// the review is published the status tells why: 422 when the text was rejected,
+// 503 when the NLP service is timing out or down, and 202 when the review is still
+// queued or was refused by the NLP service and waits for a moderator. The analysis
+// of a hidden or reported review isn't shown.
+func GetReviewAnalysisHandler(c *gin.Context) {
+	review, err := database.GetReview(c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		log.Printf("Error getting review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analysis"})
+		return
+	}
+
+	if review.Status != internal.ReviewPublished {
+		analysisPending(c, review)
+		return
+	}
+
+	result, err := database.GetNLPResult(review.ID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found"})
+			return
+		}
+		log.Printf("Error getting analysis: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analysis"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func analysisPending(c *gin.Context, review internal.Review) {
+	switch {
+	case review.Status == internal.ReviewRejected:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Review was rejected"})
+	case review.Status == internal.ReviewAnalysisFailed:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Review could not be analyzed, it waits for a moderator"})
+	case review.Status == internal.ReviewPending && review.Moderation == internal.ModerationProfanity:
+		c.JSON(http.StatusAccepted, gin.H{"status": review.Status})
+	case review.Status != internal.ReviewPendingAnalysis:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis not found"})
+	case review.AnalysisError == "timeout" || review.AnalysisError == "unavailable":
+		c.Header("Retry-After", strconv.Itoa(int(nlp.RetryAfter().Seconds())))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Analysis service is unavailable, the review will be scored when it is back"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"status": review.Status})
+	}
+}
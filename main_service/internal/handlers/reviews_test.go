@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"restaurant_reviews/internal"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsModerated(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{internal.ReviewPendingAnalysis, false},
+		{internal.ReviewAnalysisFailed, false},
+		{internal.ReviewPublished, false},
+		{internal.ReviewPending, true},
+		{internal.ReviewHidden, true},
+		{internal.ReviewRejected, true},
+	}
+
+	for _, test := range tests {
+		got := isModerated(internal.Review{Status: test.status})
+		if got != test.want {
+			t.Errorf("isModerated(%s) = %v, want %v", test.status, got, test.want)
+		}
+	}
+}
+
+func TestAnalysisPending(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		review internal.Review
+		want   int
+	}{
+		{internal.Review{Status: internal.ReviewPendingAnalysis}, http.StatusAccepted},
+		{internal.Review{Status: internal.ReviewPendingAnalysis, AnalysisError: "timeout"}, http.StatusServiceUnavailable},
+		{internal.Review{Status: internal.ReviewAnalysisFailed}, http.StatusUnprocessableEntity},
+		{internal.Review{Status: internal.ReviewRejected}, http.StatusUnprocessableEntity},
+		{internal.Review{Status: internal.ReviewPending, Moderation: internal.ModerationProfanity}, http.StatusAccepted},
+		{internal.Review{Status: internal.ReviewHidden}, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+
+		analysisPending(c, test.review)
+		if recorder.Code != test.want {
+			t.Errorf("analysisPending(%s %s) = %d, want %d", test.review.Status, test.review.AnalysisError, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		query string
+		page  int64
+		limit int64
+		ok    bool
+	}{
+		{"", 1, defaultReviewsLimit, true},
+		{"page=3&limit=50", 3, 50, true},
+		{"page=10000", maxPage, defaultReviewsLimit, true},
+		{"page=10001", 0, 0, false},
+		{"page=9223372036854775807", 0, 0, false},
+		{"page=0", 0, 0, false},
+		{"page=x", 0, 0, false},
+		{"limit=0", 0, 0, false},
+		{"limit=101", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/?"+test.query, nil)
+
+		page, limit, ok := parsePage(c)
+		if page != test.page || limit != test.limit || ok != test.ok {
+			t.Errorf("parsePage(%q) = %d, %d, %v, want %d, %d, %v", test.query, page, limit, ok, test.page, test.limit, test.ok)
+		}
+		if !ok && recorder.Code != http.StatusBadRequest {
+			t.Errorf("parsePage(%q) answered %d, want %d", test.query, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -1,84 +1,191 @@
 package jwtAuth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"restaurant_reviews/config"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
-	"net/http"
-	"time"
 )
 
-var secret = []byte("karpliak vasyl")
+// ClaimsKey is the gin context key the auth middleware stores Claims under.
+const ClaimsKey = "claims"
 
-func CreateToken(email string, role string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-		"email": email,
-		"role":  role,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
-	})
+var (
+	secret     []byte
+	accessTTL  = 15 * time.Minute
+	refreshTTL = 30 * 24 * time.Hour
 
-	tokenString, err := token.SignedString(secret)
-	if err != nil {
-		return "", err
-	}
+	// signingKey and verificationKeys are only set for asymmetric algorithms,
+	// otherwise tokens are signed and verified with secret.
+	signingKey       *key
+	verificationKeys = map[string]*key{}
+)
 
-	return tokenString, nil
+type Claims struct {
+	ID        string
+	UserID    string
+	Email     string
+	Role      string
+	ExpiresAt time.Time
 }
 
-func JWTDecode(tokenString string) (string, string, error) {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
+func Configure(cfg config.JWTConfig) error {
+	secret = []byte(cfg.Secret)
+	accessTTL = cfg.AccessTTL
+	refreshTTL = cfg.RefreshTTL
 
-	if err != nil {
-		return "", "", fmt.Errorf("не вдалося розпарсити токен: %w", err)
+	if cfg.Algorithm == "HS512" {
+		signingKey = nil
+		verificationKeys = map[string]*key{}
+		return nil
 	}
 
-	emailRaw, okEmail := claims["email"]
-	roleRaw, okRole := claims["role"]
+	keys, err := loadKeys(cfg.KeysDir, cfg.Algorithm)
+	if err != nil {
+		return err
+	}
 
-	if !okEmail || !okRole {
-		return "", "", fmt.Errorf("відсутні обов'язкові поля в токені")
+	active, ok := keys[cfg.ActiveKeyID]
+	if !ok || active.Private == nil {
+		return fmt.Errorf("active key %q has no private key in %s", cfg.ActiveKeyID, cfg.KeysDir)
 	}
 
-	email, okEmailCast := emailRaw.(string)
-	role, okRoleCast := roleRaw.(string)
+	signingKey = active
+	verificationKeys = keys
+	return nil
+}
+
+func AccessTTL() time.Duration {
+	return accessTTL
+}
+
+func RefreshTTL() time.Duration {
+	return refreshTTL
+}
 
-	if !okEmailCast || !okRoleCast {
-		return "", "", fmt.Errorf("не вдалося привести типи до string")
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
 	}
 
-	return email, role, nil
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
-func GetJWTDataFromCookie(c *gin.Context) (string, string, error) {
-	tokenString, err := c.Request.Cookie("jwt")
+// CreateToken issues a short-lived access token. Its jti claim lets the token be
+// revoked before it expires.
+func CreateToken(userID string, email string, role string) (string, error) {
+	jti, err := randomString(16)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"jti":   jti,
+		"sub":   userID,
+		"email": email,
+		"role":  role,
+		"exp":   time.Now().Add(accessTTL).Unix(),
 	}
 
-	email, role, err := JWTDecode(tokenString.Value)
+	var tokenString string
+	if signingKey != nil {
+		token := jwt.NewWithClaims(signingKey.Method, claims)
+		token.Header["kid"] = signingKey.ID
+		tokenString, err = token.SignedString(signingKey.Private)
+	} else {
+		tokenString, err = jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(secret)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		c.Abort()
+		return "", err
 	}
 
-	return email, role, nil
+	return tokenString, nil
 }
 
-func VerifyToken(tokenString string) error {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+// CreateRefreshToken returns an opaque refresh token and the hash that is stored
+// in the database in its place.
+func CreateRefreshToken() (string, string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, HashRefreshToken(token), nil
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyFunc picks the verification key named by the kid header, making sure the
+// token's algorithm matches the key so an RSA public key is never used as an HMAC secret.
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	if signingKey == nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
 		return secret, nil
-	})
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	k, ok := verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return k.Public, nil
+}
+
+// ParseToken verifies the signature and expiry of the token and returns its claims.
+func ParseToken(tokenString string) (Claims, error) {
+	mapClaims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, mapClaims, keyFunc)
 
 	if err != nil {
-		return err
+		return Claims{}, fmt.Errorf("не вдалося розпарсити токен: %w", err)
 	}
 
 	if !token.Valid {
-		return fmt.Errorf("invalid token")
+		return Claims{}, fmt.Errorf("invalid token")
 	}
 
-	return nil
+	jti, okJTI := mapClaims["jti"].(string)
+	userID, okUserID := mapClaims["sub"].(string)
+	email, okEmail := mapClaims["email"].(string)
+	role, okRole := mapClaims["role"].(string)
+	exp, okExp := mapClaims["exp"].(float64)
+
+	if !okJTI || !okUserID || !okEmail || !okRole || !okExp {
+		return Claims{}, fmt.Errorf("відсутні обов'язкові поля в токені")
+	}
+
+	return Claims{
+		ID:        jti,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// GetClaims returns the claims stored by the auth middleware.
+func GetClaims(c *gin.Context) (Claims, bool) {
+	value, ok := c.Get(ClaimsKey)
+	if !ok {
+		return Claims{}, false
+	}
+
+	claims, ok := value.(Claims)
+	return claims, ok
 }
@@ -0,0 +1,142 @@
+package jwtAuth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// key is one asymmetric key from the keys directory. The key ID is the file name
+// without its .pem extension; only the active key needs a private part.
+type key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// loadKeys reads every *.pem file in dir. Each file holds either a private key or,
+// for retired keys that only verify old tokens, a public key.
+func loadKeys(dir string, algorithm string) (map[string]*key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	keys := map[string]*key{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %v", path, err)
+		}
+
+		k, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %s: %v", path, err)
+		}
+
+		if k.Method.Alg() != algorithm {
+			return nil, fmt.Errorf("key %s is for %s, expected %s", path, k.Method.Alg(), algorithm)
+		}
+
+		k.ID = strings.TrimSuffix(filepath.Base(path), ".pem")
+		keys[k.ID] = k
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", dir)
+	}
+
+	return keys, nil
+}
+
+func parseKey(data []byte) (*key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &key{Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}, nil
+
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			return &key{Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}, nil
+		case ed25519.PrivateKey:
+			return &key{Method: jwt.SigningMethodEdDSA, Private: private, Public: private.Public()}, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", private)
+
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch public := public.(type) {
+		case *rsa.PublicKey:
+			return &key{Method: jwt.SigningMethodRS256, Public: public}, nil
+		case ed25519.PublicKey:
+			return &key{Method: jwt.SigningMethodEdDSA, Public: public}, nil
+		}
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set. It is empty
+// when tokens are signed with the shared HMAC secret.
+func JWKS() map[string]interface{} {
+	ids := make([]string, 0, len(verificationKeys))
+	for id := range verificationKeys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := []map[string]string{}
+	for _, id := range ids {
+		k := verificationKeys[id]
+
+		entry := map[string]string{
+			"kid": k.ID,
+			"alg": k.Method.Alg(),
+			"use": "sig",
+		}
+
+		switch public := k.Public.(type) {
+		case *rsa.PublicKey:
+			entry["kty"] = "RSA"
+			entry["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			entry["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			entry["kty"] = "OKP"
+			entry["crv"] = "Ed25519"
+			entry["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+
+		jwks = append(jwks, entry)
+	}
+
+	return map[string]interface{}{"keys": jwks}
+}
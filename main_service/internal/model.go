@@ -18,10 +18,20 @@ type Category struct {
 	Name string `bson:"name" json:"name"`
 }
 
+// Location is stored as a GeoJSON Point so the 2dsphere index can serve $near queries.
+// Coordinates are in GeoJSON order: longitude first, then latitude.
 type Location struct {
-	Latitude  float64 `bson:"latitude" json:"latitude"`
-	Longitude float64 `bson:"longitude" json:"longitude"`
-	Address   string  `bson:"address" json:"address"`
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+	Address     string    `bson:"address" json:"address"`
+}
+
+func NewLocation(latitude float64, longitude float64, address string) Location {
+	return Location{
+		Type:        "Point",
+		Coordinates: []float64{longitude, latitude},
+		Address:     address,
+	}
 }
 
 type Restaurant struct {
@@ -31,37 +41,236 @@ type Restaurant struct {
 	Location   Location `bson:"location" json:"location"`
 }
 
+// Review states. Only published reviews count towards the restaurant rating. Pending
+// reviews wait for a moderator, hidden ones were taken down by one. Reviews whose
+// scoring ran out of attempts wait for an admin to requeue or approve them.
+const (
+	ReviewPendingAnalysis = "pending_analysis"
+	ReviewAnalysisFailed  = "analysis_failed"
+	ReviewPublished       = "published"
+	ReviewPending         = "pending"
+	ReviewHidden          = "hidden"
+	ReviewRejected        = "rejected"
+)
+
+// Why a review was sent to moderation.
+const (
+	ModerationProfanity = "profanity"
+	ModerationFlagged   = "flagged"
+)
+
+// Aspects a review can rate separately, each on the same 0-5 scale as the rating.
+var Aspects = []string{"food", "service", "ambience", "value"}
+
+func IsAspect(name string) bool {
+	for _, aspect := range Aspects {
+		if aspect == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Review.Visit numbers a user's reviews of one restaurant from 1; it only goes
+// past 1 when reviews are kept per visit. Revision is bumped on every edit so a
+// scoring job for an outdated text can't overwrite the result for the current
+// one. Rating is the rating the review counts with once published, computed from
+// UserRating and NLPRating by the rating policy named in PolicyVersion. Aspects
+// are likewise the user's UserAspects with the aspects they left out filled in
+// from the text. Flags counts the reports since a moderator last looked at the
+// review and Moderation says why it is pending. Uncounted marks a published
+// revision whose rating isn't in the restaurant aggregate yet.
 type Review struct {
-	ID           string    `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID       string    `bson:"userId" json:"userId"`
-	RestaurantID string    `bson:"restaurantId" json:"restaurantId"`
-	Text         string    `bson:"text" json:"text"`
-	Rating       float64   `bson:"rating" json:"rating"`
-	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
+	ID            string             `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        string             `bson:"userId" json:"userId"`
+	RestaurantID  string             `bson:"restaurantId" json:"restaurantId"`
+	Visit         int                `bson:"visit" json:"visit"`
+	Text          string             `bson:"text" json:"text"`
+	Rating        float64            `bson:"rating" json:"rating"`
+	UserRating    float64            `bson:"userRating" json:"userRating"`
+	NLPRating     *float64           `bson:"nlpRating,omitempty" json:"nlpRating,omitempty"`
+	PolicyVersion string             `bson:"policyVersion,omitempty" json:"policyVersion,omitempty"`
+	UserAspects   map[string]float64 `bson:"userAspects,omitempty" json:"userAspects,omitempty"`
+	Aspects       map[string]float64 `bson:"aspects,omitempty" json:"aspects,omitempty"`
+	Status        string             `bson:"status" json:"status"`
+	AnalysisError string             `bson:"analysisError,omitempty" json:"analysisError,omitempty"`
+	Flags         int                `bson:"flags,omitempty" json:"flags,omitempty"`
+	Moderation    string             `bson:"moderation,omitempty" json:"moderation,omitempty"`
+	Uncounted     bool               `bson:"uncounted,omitempty" json:"-"`
+	Revision      int                `bson:"revision" json:"-"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// ReviewFlag is a user's report of a published review. A user can report a review
+// once until a moderator decides on it.
+type ReviewFlag struct {
+	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	ReviewID  string    `bson:"reviewId" json:"reviewId"`
+	UserID    string    `bson:"userId" json:"userId"`
+	Reason    string    `bson:"reason" json:"reason"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ModerationItem is a review in the moderation queue with the reports against it.
+type ModerationItem struct {
+	Review  `bson:",inline"`
+	Reports []ReviewFlag `bson:"reports" json:"reports"`
+}
+
+// ReviewScore is the outcome of scoring a review revision.
+type ReviewScore struct {
+	Rating        float64
+	NLPRating     float64
+	PolicyVersion string
+	Aspects       map[string]float64
 }
 
 type NLPResult struct {
-	ID        string   `bson:"_id,omitempty" json:"id,omitempty"`
-	ReviewID  string   `bson:"reviewId" json:"reviewId"`
-	Sentiment string   `bson:"sentiment" json:"sentiment"`
-	Keywords  []string `bson:"keywords" json:"keywords"`
+	ID         string             `bson:"_id,omitempty" json:"id,omitempty"`
+	ReviewID   string             `bson:"reviewId" json:"reviewId"`
+	Sentiment  string             `bson:"sentiment" json:"sentiment"`
+	Polarity   float64            `bson:"polarity" json:"polarity"`
+	Language   string             `bson:"language" json:"language"`
+	Keywords   []string           `bson:"keywords" json:"keywords"`
+	Rating     float64            `bson:"rating" json:"rating"`
+	Aspects    map[string]float64 `bson:"aspects,omitempty" json:"aspects,omitempty"`
+	AnalyzedAt time.Time          `bson:"analyzedAt" json:"analyzedAt"`
+}
+
+// NLPJob asks a worker to score one revision of a review. Jobs that keep failing
+// are moved to the dead-letter collection.
+type NLPJob struct {
+	ID          string     `bson:"_id,omitempty" json:"id,omitempty"`
+	ReviewID    string     `bson:"reviewId" json:"reviewId"`
+	Revision    int        `bson:"revision" json:"revision"`
+	UserRating  float64    `bson:"userRating" json:"userRating"`
+	Status      string     `bson:"status" json:"status"`
+	Attempts    int        `bson:"attempts" json:"attempts"`
+	RunAt       time.Time  `bson:"runAt" json:"runAt"`
+	LockedUntil *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	LastError   string     `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	FailedAt    *time.Time `bson:"failedAt,omitempty" json:"failedAt,omitempty"`
+}
+
+// Rescore modes: "nlp" scores the texts again, "policy" only applies the current
+// rating policy to the stored ratings.
+const (
+	RescoreNLP    = "nlp"
+	RescorePolicy = "policy"
+)
+
+const (
+	RescoreRunning  = "running"
+	RescoreFinished = "finished"
+	RescoreFailed   = "failed"
+)
+
+// RescoreJob tracks a rescoring run over all published reviews. Only one job can be
+// running at a time. Owner names the process running it, which keeps renewing
+// LockedUntil; a running job whose lock ran out was left behind by a dead process.
+type RescoreJob struct {
+	ID          string     `bson:"_id,omitempty" json:"id,omitempty"`
+	AdminID     string     `bson:"adminId" json:"adminId"`
+	Mode        string     `bson:"mode" json:"mode"`
+	Status      string     `bson:"status" json:"status"`
+	Owner       string     `bson:"owner,omitempty" json:"owner,omitempty"`
+	LockedUntil *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	Total       int64      `bson:"total" json:"total"`
+	Processed   int64      `bson:"processed" json:"processed"`
+	Failed      int64      `bson:"failed" json:"failed"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+	StartedAt   time.Time  `bson:"startedAt" json:"startedAt"`
+	FinishedAt  *time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
 }
 
+// Rating is the aggregate of a restaurant's published reviews. Histogram counts
+// reviews by rounded stars, one to five, and Monthly holds the reviews written in
+// each month keyed "2006-01". DecayedSum and DecayedWeight are the totals with every
+// review weighted down by its age, as of DecayedAt; they feed the ranking score.
 type Rating struct {
-	ID            string  `bson:"_id,omitempty" json:"id,omitempty"`
-	RestaurantID  string  `bson:"restaurantId" json:"restaurantId"`
-	AverageRating float64 `bson:"averageRating" json:"averageRating"`
-	ReviewCount   int     `bson:"reviewCount" json:"reviewCount"`
+	ID            string                   `bson:"_id,omitempty" json:"id,omitempty"`
+	RestaurantID  string                   `bson:"restaurantId" json:"restaurantId"`
+	AverageRating float64                  `bson:"averageRating" json:"averageRating"`
+	ReviewCount   int                      `bson:"reviewCount" json:"reviewCount"`
+	RatingSum     float64                  `bson:"ratingSum" json:"-"`
+	Histogram     []int                    `bson:"histogram" json:"-"`
+	Monthly       map[string]MonthlyRating `bson:"monthly" json:"-"`
+	Aspects       map[string]AspectRating  `bson:"aspects" json:"aspects,omitempty"`
+	DecayedSum    float64                  `bson:"decayedSum" json:"-"`
+	DecayedWeight float64                  `bson:"decayedWeight" json:"-"`
+	DecayedAt     time.Time                `bson:"decayedAt" json:"-"`
+}
+
+type AspectRating struct {
+	Count   int     `bson:"count" json:"count"`
+	Sum     float64 `bson:"sum" json:"-"`
+	Average float64 `bson:"average" json:"average"`
+}
+
+type MonthlyRating struct {
+	Count int     `bson:"count" json:"count"`
+	Sum   float64 `bson:"sum" json:"-"`
+}
+
+// RestaurantStats is the rating distribution and monthly trend of a restaurant.
+// Months without reviews have no average.
+type RestaurantStats struct {
+	RestaurantID  string         `json:"restaurantId"`
+	ReviewCount   int            `json:"reviewCount"`
+	AverageRating float64        `json:"averageRating"`
+	Distribution  []StarCount    `json:"distribution"`
+	Trend         []MonthlyTrend `json:"trend"`
 }
 
+type StarCount struct {
+	Stars int `json:"stars"`
+	Count int `json:"count"`
+}
+
+type MonthlyTrend struct {
+	Month         string   `json:"month"`
+	Count         int      `json:"count"`
+	AverageRating *float64 `json:"averageRating"`
+}
+
+// Admin log actions: reads are GET, HEAD and OPTIONS requests, everything else is a
+// write.
+const (
+	AdminLogRead  = "read"
+	AdminLogWrite = "write"
+)
+
+// AdminLog records a request to an admin-only route. Action says whether it read
+// or wrote, ActionType names what the handler did, or the route when it didn't
+// say, and Before and After are snapshots of the Target it acted on.
 type AdminLog struct {
 	ID         string    `bson:"_id,omitempty" json:"id,omitempty"`
 	AdminID    string    `bson:"adminId" json:"adminId"`
+	Action     string    `bson:"action" json:"action"`
 	ActionType string    `bson:"actionType" json:"actionType"`
+	Target     string    `bson:"target,omitempty" json:"target,omitempty"`
 	Details    string    `bson:"details" json:"details"`
+	RequestID  string    `bson:"requestId" json:"requestId"`
+	Method     string    `bson:"method" json:"method"`
+	Path       string    `bson:"path" json:"path"`
+	Status     int       `bson:"status" json:"status"`
+	ClientIP   string    `bson:"clientIp" json:"clientIp"`
+	Before     Snapshot  `bson:"before,omitempty" json:"before,omitempty"`
+	After      Snapshot  `bson:"after,omitempty" json:"after,omitempty"`
 	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
 }
 
+// Snapshot is a JSON document, stored as a string and written into responses as is.
+type Snapshot string
+
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return []byte("null"), nil
+	}
+	return []byte(s), nil
+}
+
 type Favorite struct {
 	ID           string    `bson:"_id,omitempty" json:"id,omitempty"`
 	UserID       string    `bson:"userId" json:"userId"`
@@ -69,8 +278,88 @@ type Favorite struct {
 	AddedAt      time.Time `bson:"addedAt" json:"addedAt"`
 }
 
+// Collection visibilities. Unlisted collections can be opened by anyone with the
+// share link, public ones are also listed and can be copied by other users.
+const (
+	CollectionPrivate  = "private"
+	CollectionUnlisted = "unlisted"
+	CollectionPublic   = "public"
+)
+
+// Collection is a named list of restaurants a user put together, kept in the order
+// the user arranged them. ShareToken is only shown to the owner.
+type Collection struct {
+	ID         string            `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     string            `bson:"userId" json:"userId"`
+	Name       string            `bson:"name" json:"name"`
+	Visibility string            `bson:"visibility" json:"visibility"`
+	ShareToken string            `bson:"shareToken" json:"shareToken,omitempty"`
+	Entries    []CollectionEntry `bson:"entries" json:"entries,omitempty"`
+	CopiedFrom string            `bson:"copiedFrom,omitempty" json:"copiedFrom,omitempty"`
+	Revision   int               `bson:"revision" json:"revision"`
+	CreatedAt  time.Time         `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time         `bson:"updatedAt" json:"updatedAt"`
+}
+
+type CollectionEntry struct {
+	RestaurantID string    `bson:"restaurantId" json:"restaurantId"`
+	Note         string    `bson:"note,omitempty" json:"note,omitempty"`
+	AddedAt      time.Time `bson:"addedAt" json:"addedAt"`
+}
+
+// CollectionWithRestaurants is a collection with its restaurants and their ratings
+// in place of the bare entries.
+type CollectionWithRestaurants struct {
+	Collection
+	Restaurants []CollectionRestaurant `json:"restaurants"`
+}
+
+type CollectionRestaurant struct {
+	RestaurantWithRating
+	Note    string    `json:"note,omitempty"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// RefreshToken is stored by hash only. Every rotation creates a new token in the same
+// family, so presenting an already used token revokes the whole family.
+type RefreshToken struct {
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string     `bson:"userId" json:"userId"`
+	FamilyID  string     `bson:"familyId" json:"familyId"`
+	TokenHash string     `bson:"tokenHash" json:"-"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time  `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	Revoked   bool       `bson:"revoked" json:"revoked"`
+}
+
 type RatingResponse struct {
-	TextReview string  `json:"review"`
-	Status     bool    `json:"status"`
-	Rating     float64 `json:"rating"`
+	TextReview string             `json:"review"`
+	Status     bool               `json:"status"`
+	Rating     float64            `json:"rating"`
+	Sentiment  string             `json:"sentiment"`
+	Polarity   float64            `json:"polarity"`
+	Language   string             `json:"language"`
+	Keywords   []string           `json:"keywords"`
+	Aspects    map[string]float64 `json:"aspects"`
+}
+
+type RestaurantWithRating struct {
+	Restaurant `bson:",inline"`
+	Rating     *Rating `bson:"rating,omitempty" json:"rating,omitempty"`
+}
+
+type RankedRestaurant struct {
+	RestaurantWithRating `bson:",inline"`
+	RankingScore         float64 `bson:"rankingScore" json:"rankingScore"`
+}
+
+type FavoriteRestaurant struct {
+	RestaurantWithRating `bson:",inline"`
+	AddedAt              time.Time `bson:"addedAt" json:"addedAt"`
+}
+
+type NearbyRestaurant struct {
+	RestaurantWithRating `bson:",inline"`
+	Distance             float64 `bson:"distance" json:"distance"`
 }
@@ -0,0 +1,116 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"restaurant_reviews/internal"
+	"sync"
+)
+
+// BatchScorer is implemented by scorers that can rate many texts in one call.
+type BatchScorer interface {
+	ScoreBatch(ctx context.Context, texts []string) ([]BatchResult, error)
+}
+
+// BatchResult is the outcome for one text of a batch. Err is set instead of Rating
+// when that text couldn't be scored, e.g. ErrRejected.
+type BatchResult struct {
+	Rating internal.RatingResponse
+	Err    error
+}
+
+// ScoreBatch rates texts with the configured scorer, sending them in chunks of the
+// configured batch size with at most the configured number of chunks in flight.
+// Results are in the same order as texts. Texts that are refused or too long get
+// their error in the result; when a request fails the rest are abandoned and its
+// error is returned.
+func ScoreBatch(ctx context.Context, texts []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(texts))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var failOnce sync.Once
+	var failure error
+	slots := make(chan struct{}, batchConcurrency)
+
+	for start := 0; start < len(texts) && ctx.Err() == nil; start += batchSize {
+		end := min(start+batchSize, len(texts))
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			chunk, err := scoreChunk(ctx, texts[start:end])
+			if err != nil {
+				failOnce.Do(func() {
+					failure = err
+					cancel()
+				})
+				return
+			}
+			copy(results[start:end], chunk)
+		}(start, end)
+	}
+
+	wg.Wait()
+	if failure != nil {
+		return nil, failure
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func scoreChunk(ctx context.Context, texts []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(texts))
+
+	// Over-long texts are answered here and left out of the request
+	var pending []string
+	var positions []int
+	for i, text := range texts {
+		if err := CheckLength(text); err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, text)
+		positions = append(positions, i)
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	scored, err := scoreTexts(ctx, scorer, pending)
+	if err != nil {
+		return nil, err
+	}
+	for i, position := range positions {
+		results[position] = scored[i]
+	}
+
+	return results, nil
+}
+
+// scoreTexts sends the texts to s in one batch when it can take one, or one by
+// one otherwise.
+func scoreTexts(ctx context.Context, s Scorer, texts []string) ([]BatchResult, error) {
+	if batchScorer, ok := s.(BatchScorer); ok {
+		return batchScorer.ScoreBatch(ctx, texts)
+	}
+
+	results := make([]BatchResult, len(texts))
+	for i, text := range texts {
+		rating, err := s.Score(ctx, text)
+		if err != nil && !errors.Is(err, ErrRejected) {
+			return nil, err
+		}
+		results[i] = BatchResult{Rating: rating, Err: err}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,36 @@
+package nlp
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTimeout means the NLP service didn't answer in time.
+	ErrTimeout = errors.New("nlp service timed out")
+	// ErrUnavailable means the NLP service couldn't be reached or failed.
+	ErrUnavailable = errors.New("nlp service unavailable")
+	// ErrCircuitOpen is returned without calling the service while the circuit
+	// breaker is open. It matches ErrUnavailable with errors.Is.
+	ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", ErrUnavailable)
+	// ErrRejected means the text was refused, e.g. for profanity. Asking again
+	// gives the same answer.
+	ErrRejected = errors.New("review rejected for profanity")
+	// ErrTextTooLong means the text is over the configured maximum length.
+	ErrTextTooLong = errors.New("review text too long")
+)
+
+// ErrorKind names the class of a scorer error so it can be stored with a review:
+// "timeout", "unavailable", "rejected", or "" for any other error.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrRejected):
+		return "rejected"
+	}
+
+	return ""
+}
@@ -0,0 +1,86 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"restaurant_reviews/internal"
+	"sync/atomic"
+	"time"
+)
+
+// FallbackScorer uses primary while it passes health checks and fallback otherwise.
+// A failed call to primary also switches to fallback until the next successful probe.
+type FallbackScorer struct {
+	primary  Scorer
+	fallback Scorer
+	healthy  atomic.Bool
+}
+
+func NewFallbackScorer(primary Scorer, fallback Scorer) *FallbackScorer {
+	s := &FallbackScorer{primary: primary, fallback: fallback}
+	s.healthy.Store(true)
+	return s
+}
+
+func (s *FallbackScorer) Name() string {
+	return s.primary.Name() + " with " + s.fallback.Name() + " fallback"
+}
+
+func (s *FallbackScorer) Score(ctx context.Context, text string) (internal.RatingResponse, error) {
+	if s.healthy.Load() {
+		rating, err := s.primary.Score(ctx, text)
+		if err == nil || errors.Is(err, ErrRejected) {
+			return rating, err
+		}
+
+		log.Printf("NLP %s scorer failed, falling back to %s: %v", s.primary.Name(), s.fallback.Name(), err)
+		s.healthy.Store(false)
+	}
+
+	return s.fallback.Score(ctx, text)
+}
+
+// ScoreBatch scores the texts with primary only. Bulk scoring rewrites stored
+// results, so while primary is unhealthy or when it fails the batch fails instead
+// of being scored by fallback. A failed batch leaves the health flag alone.
+func (s *FallbackScorer) ScoreBatch(ctx context.Context, texts []string) ([]BatchResult, error) {
+	if !s.healthy.Load() {
+		return nil, fmt.Errorf("%w: %s scorer is unhealthy", ErrUnavailable, s.primary.Name())
+	}
+
+	return scoreTexts(ctx, s.primary, texts)
+}
+
+func (s *FallbackScorer) Healthy(ctx context.Context) error {
+	if err := s.primary.Healthy(ctx); err != nil {
+		return s.fallback.Healthy(ctx)
+	}
+
+	return nil
+}
+
+// Probe checks the primary scorer every interval until ctx is cancelled.
+func (s *FallbackScorer) Probe(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.primary.Healthy(ctx)
+		healthy := err == nil
+		if s.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Printf("NLP %s scorer is healthy again", s.primary.Name())
+			} else {
+				log.Printf("NLP %s scorer failed health check, using %s: %v", s.primary.Name(), s.fallback.Name(), err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"restaurant_reviews/internal"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeScorer answers with its name as the sentiment, or with err when it is set.
+type fakeScorer struct {
+	name string
+
+	mu        sync.Mutex
+	err       error
+	healthErr error
+}
+
+func (s *fakeScorer) Name() string {
+	return s.name
+}
+
+func (s *fakeScorer) Score(ctx context.Context, text string) (internal.RatingResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return internal.RatingResponse{}, s.err
+	}
+	return internal.RatingResponse{TextReview: text, Status: true, Rating: 3, Sentiment: s.name}, nil
+}
+
+func (s *fakeScorer) Healthy(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.healthErr
+}
+
+func (s *fakeScorer) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+	s.healthErr = err
+}
+
+func checkScoredBy(t *testing.T, s *FallbackScorer, want string) {
+	t.Helper()
+
+	rating, err := s.Score(context.Background(), "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rating.Sentiment != want {
+		t.Errorf("scored by %s, want %s", rating.Sentiment, want)
+	}
+}
+
+func TestFallbackScorer(t *testing.T) {
+	primary := &fakeScorer{name: "primary"}
+	fallback := &fakeScorer{name: "fallback"}
+	s := NewFallbackScorer(primary, fallback)
+
+	checkScoredBy(t, s, "primary")
+
+	// a rejected text is an answer, not a failure
+	primary.fail(ErrRejected)
+	if _, err := s.Score(context.Background(), "text"); !errors.Is(err, ErrRejected) {
+		t.Fatalf("err = %v, want %v", err, ErrRejected)
+	}
+	if !s.healthy.Load() {
+		t.Fatal("rejected text marked primary unhealthy")
+	}
+
+	primary.fail(ErrUnavailable)
+	checkScoredBy(t, s, "fallback")
+	if s.healthy.Load() {
+		t.Fatal("failed primary still healthy")
+	}
+
+	// primary stays out until a probe finds it healthy again
+	primary.fail(nil)
+	checkScoredBy(t, s, "fallback")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Probe(ctx, time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitHealthy(t, s, true)
+	checkScoredBy(t, s, "primary")
+
+	primary.fail(ErrTimeout)
+	waitHealthy(t, s, false)
+	checkScoredBy(t, s, "fallback")
+}
+
+func waitHealthy(t *testing.T, s *FallbackScorer, want bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for s.healthy.Load() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("healthy = %v after probing, want %v", !want, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFallbackScorerBatch(t *testing.T) {
+	primary := &fakeScorer{name: "primary"}
+	fallback := &fakeScorer{name: "fallback"}
+	s := NewFallbackScorer(primary, fallback)
+
+	results, err := s.ScoreBatch(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Err != nil || result.Rating.Sentiment != "primary" {
+			t.Errorf("result %d = %+v, want scored by primary", i, result)
+		}
+	}
+
+	// a failed batch is reported, not scored by fallback, and leaves health alone
+	primary.fail(ErrUnavailable)
+	if _, err := s.ScoreBatch(context.Background(), []string{"a"}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want %v", err, ErrUnavailable)
+	}
+	if !s.healthy.Load() {
+		t.Fatal("failed batch marked primary unhealthy")
+	}
+
+	// an unhealthy primary fails the batch without calling it
+	s.healthy.Store(false)
+	primary.fail(nil)
+	if _, err := s.ScoreBatch(context.Background(), []string{"a"}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want %v", err, ErrUnavailable)
+	}
+	if s.healthy.Load() {
+		t.Fatal("batch marked primary healthy")
+	}
+}
@@ -0,0 +1,259 @@
+package nlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"restaurant_reviews/internal"
+	"strings"
+	"time"
+)
+
+// HTTPScorer calls the Python NLP service over a pooled client. Transient failures
+// are retried a bounded number of times and a circuit breaker stops calling the
+// service while it keeps failing. A batch request gets timeout plus batchTextTimeout
+// for each of its texts, as the service scores them one after another.
+type HTTPScorer struct {
+	baseURL          string
+	timeout          time.Duration
+	batchTextTimeout time.Duration
+	retries          int
+	client           *http.Client
+	breaker          *breaker
+}
+
+func NewHTTPScorer(baseURL string, timeout time.Duration, batchTextTimeout time.Duration, retries int, breakerThreshold int, breakerCooldown time.Duration) *HTTPScorer {
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConns:        32,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return &HTTPScorer{
+		baseURL:          strings.TrimRight(baseURL, "/"),
+		timeout:          timeout,
+		batchTextTimeout: batchTextTimeout,
+		retries:          retries,
+		client:           &http.Client{Transport: transport},
+		breaker:          newBreaker(breakerThreshold, breakerCooldown),
+	}
+}
+
+func (s *HTTPScorer) Name() string {
+	return "http"
+}
+
+func (s *HTTPScorer) Score(ctx context.Context, text string) (internal.RatingResponse, error) {
+	var rating internal.RatingResponse
+
+	err := s.withRetries(ctx, func() error {
+		var err error
+		rating, err = s.score(ctx, text)
+		return err
+	})
+
+	return rating, err
+}
+
+// ScoreBatch rates all texts in one request. A text the service refuses gets
+// ErrRejected in its result, a failed request fails the whole batch.
+func (s *HTTPScorer) ScoreBatch(ctx context.Context, texts []string) ([]BatchResult, error) {
+	var ratings []internal.RatingResponse
+
+	err := s.withRetries(ctx, func() error {
+		var err error
+		ratings, err = s.scoreBatch(ctx, texts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(ratings))
+	for i, rating := range ratings {
+		results[i].Rating = rating
+		if !rating.Status {
+			results[i].Err = ErrRejected
+		}
+	}
+
+	return results, nil
+}
+
+// withRetries runs call through the circuit breaker, retrying timeouts and
+// unavailable errors up to s.retries times. Only those count as breaker failures:
+// any other error means the service answered, and a call the caller cancelled
+// says nothing about the service either way.
+func (s *HTTPScorer) withRetries(ctx context.Context, call func() error) error {
+	var err error
+
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryDelay(attempt)):
+			}
+		}
+
+		if !s.breaker.Allow() {
+			return ErrCircuitOpen
+		}
+
+		err = call()
+		switch {
+		case ctx.Err() != nil:
+			s.breaker.Release()
+			return err
+		case errors.Is(err, ErrTimeout), errors.Is(err, ErrUnavailable):
+			s.breaker.Failure()
+		default:
+			s.breaker.Success()
+			return err
+		}
+	}
+
+	return err
+}
+
+// retryDelay is how long withRetries waits before the given retry.
+func retryDelay(attempt int) time.Duration {
+	return time.Duration(attempt*attempt) * 100 * time.Millisecond
+}
+
+// CallBudget is the longest one scorer call can take with retries: every attempt
+// running into the timeout, plus the waits between them.
+func CallBudget(timeout time.Duration, retries int) time.Duration {
+	budget := time.Duration(retries+1) * timeout
+	for attempt := 1; attempt <= retries; attempt++ {
+		budget += retryDelay(attempt)
+	}
+
+	return budget
+}
+
+// rateRequest is the body of POST /rate.
+type rateRequest struct {
+	Text string `json:"text"`
+}
+
+// rateBatchRequest and rateBatchResponse are the bodies of POST /rate/batch.
+type rateBatchRequest struct {
+	Texts []string `json:"texts"`
+}
+
+type rateBatchResponse struct {
+	Results []internal.RatingResponse `json:"results"`
+}
+
+func (s *HTTPScorer) score(ctx context.Context, text string) (internal.RatingResponse, error) {
+	var rating internal.RatingResponse
+	err := s.post(ctx, "/rate", s.timeout, rateRequest{Text: text}, &rating)
+	if err != nil {
+		return internal.RatingResponse{}, err
+	}
+
+	if !rating.Status {
+		return rating, ErrRejected
+	}
+
+	return rating, nil
+}
+
+func (s *HTTPScorer) scoreBatch(ctx context.Context, texts []string) ([]internal.RatingResponse, error) {
+	var response rateBatchResponse
+	timeout := s.timeout + time.Duration(len(texts))*s.batchTextTimeout
+	err := s.post(ctx, "/rate/batch", timeout, rateBatchRequest{Texts: texts}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Results) != len(texts) {
+		return nil, fmt.Errorf("NLP service returned %d results for %d texts", len(response.Results), len(texts))
+	}
+
+	return response.Results, nil
+}
+
+// post sends body as JSON to the service path and decodes the answer into out,
+// giving up after timeout.
+func (s *HTTPScorer) post(ctx context.Context, path string, timeout time.Duration, body any, out any) error {
+	nlp_url := s.baseURL + path
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+	payload := bytes.NewReader(jsonBody)
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", nlp_url, payload)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrTimeout
+		}
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read response: %v", ErrUnavailable, err)
+	}
+
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("%w: status %d: %s", ErrUnavailable, res.StatusCode, resBody)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("NLP service returned %d: %s", res.StatusCode, resBody)
+	}
+
+	err = json.Unmarshal(resBody, out)
+	if err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	return nil
+}
+
+// Healthy probes the service. A successful probe also closes an open breaker, so
+// scoring resumes as soon as the service is back.
+func (s *HTTPScorer) Healthy(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: health check returned %d", ErrUnavailable, res.StatusCode)
+	}
+
+	s.breaker.Success()
+	return nil
+}
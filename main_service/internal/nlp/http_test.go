@@ -0,0 +1,106 @@
+package nlp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"restaurant_reviews/internal"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// FuzzScore checks that review text reaches the service byte for byte and that the
+// text the service echoes back is returned unchanged.
+func FuzzScore(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"Great food, friendly staff",
+		"Très bon repas, service rapide",
+		"美味しいラーメン 🍜",
+		`quotes " and \ backslashes`,
+		"tabs\tnewlines\r\nand separators",
+		"<script>alert(1)</script> & friends",
+		"\x00\x1f\x7f",
+	} {
+		f.Add(seed)
+	}
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request rateRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = request.Text
+
+		_ = json.NewEncoder(w).Encode(internal.RatingResponse{
+			TextReview: request.Text,
+			Status:     true,
+			Rating:     3,
+		})
+	}))
+	defer server.Close()
+
+	scorer := NewHTTPScorer(server.URL, 5*time.Second, time.Second, 0, 5, time.Second)
+
+	f.Fuzz(func(t *testing.T, text string) {
+		// JSON can only carry valid UTF-8, invalid bytes are replaced on encoding
+		if !utf8.ValidString(text) {
+			t.Skip()
+		}
+
+		rating, err := scorer.Score(context.Background(), text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if received != text {
+			t.Errorf("service received %q, want %q", received, text)
+		}
+		if rating.TextReview != text {
+			t.Errorf("review = %q, want %q", rating.TextReview, text)
+		}
+	})
+}
+
+// TestScoreBreaker checks that only transient errors open the circuit.
+func TestScoreBreaker(t *testing.T) {
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", int(status.Load()))
+	}))
+	defer server.Close()
+
+	scorer := NewHTTPScorer(server.URL, 5*time.Second, time.Second, 0, 2, time.Minute)
+
+	status.Store(http.StatusBadRequest)
+	for i := 0; i < 3; i++ {
+		_, err := scorer.Score(context.Background(), "text")
+		if err == nil || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: err = %v, want a bad request error", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := scorer.Score(ctx, "text"); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("cancelled call %d opened the circuit", i)
+		}
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	for i := 0; i < 2; i++ {
+		if _, err := scorer.Score(context.Background(), "text"); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("call %d: err = %v, want %v", i, err, ErrUnavailable)
+		}
+	}
+	if _, err := scorer.Score(context.Background(), "text"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want %v", err, ErrCircuitOpen)
+	}
+}
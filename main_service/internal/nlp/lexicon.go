@@ -0,0 +1,228 @@
+package nlp
+
+import (
+	"context"
+	"math"
+	"restaurant_reviews/internal"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// LexiconScorer is a dictionary-based English sentiment and profanity scorer. It
+// needs no network access and gives the same answer for the same text, which makes
+// it usable offline and in tests.
+type LexiconScorer struct{}
+
+var sentimentLexicon = map[string]float64{
+	"amazing": 0.9, "awesome": 0.9, "excellent": 1.0, "outstanding": 1.0, "perfect": 1.0,
+	"fantastic": 0.9, "wonderful": 0.9, "delicious": 0.8, "tasty": 0.6, "great": 0.8,
+	"love": 0.6, "loved": 0.6, "lovely": 0.5, "good": 0.7, "nice": 0.6, "friendly": 0.5,
+	"fresh": 0.4, "recommend": 0.5, "cozy": 0.4, "clean": 0.3, "fast": 0.3, "best": 1.0,
+	"enjoyed": 0.5, "pleasant": 0.5, "fine": 0.3, "cheap": 0.2, "polite": 0.4, "helpful": 0.4,
+	"bad": -0.7, "terrible": -1.0, "awful": -1.0, "horrible": -1.0, "disgusting": -1.0,
+	"worst": -1.0, "poor": -0.4, "rude": -0.6, "slow": -0.3, "cold": -0.2, "dirty": -0.6,
+	"bland": -0.5, "stale": -0.5, "overpriced": -0.5, "expensive": -0.3, "disappointing": -0.6,
+	"disappointed": -0.6, "mediocre": -0.4, "hate": -0.8, "hated": -0.8, "raw": -0.2,
+	"burnt": -0.5, "noisy": -0.3, "sick": -0.7, "avoid": -0.6, "waste": -0.6,
+}
+
+var negators = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "hardly": true,
+	"don't": true, "doesn't": true, "didn't": true, "isn't": true, "wasn't": true,
+	"aren't": true, "weren't": true, "can't": true, "won't": true, "couldn't": true,
+}
+
+var intensifiers = map[string]float64{
+	"very": 1.3, "really": 1.3, "extremely": 1.5, "so": 1.2, "super": 1.3, "too": 1.2,
+}
+
+var profaneWords = map[string]bool{
+	"fuck": true, "fucking": true, "shit": true, "bitch": true, "asshole": true,
+	"bastard": true, "dick": true, "cunt": true, "crap": true, "damn": true,
+}
+
+// aspectWords mentions of which make a sentence count towards an aspect.
+var aspectWords = map[string]map[string]bool{
+	"food": wordSet("food", "dish", "dishes", "meal", "taste", "flavor", "flavour", "menu", "pizza", "pasta",
+		"burger", "steak", "soup", "salad", "dessert", "portion", "portions", "cooked", "delicious", "tasty"),
+	"service": wordSet("service", "staff", "waiter", "waitress", "server", "waiters", "host", "manager",
+		"friendly", "rude", "polite", "attentive", "wait", "waited"),
+	"ambience": wordSet("ambience", "ambiance", "atmosphere", "decor", "music", "interior", "noisy", "quiet",
+		"cozy", "romantic", "view", "place", "room", "vibe"),
+	"value": wordSet("price", "prices", "value", "expensive", "cheap", "overpriced", "worth", "bill",
+		"cost", "affordable", "money"),
+}
+
+func wordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "for": true, "from": true, "had": true, "has": true,
+	"have": true, "i": true, "in": true, "is": true, "it": true, "its": true, "me": true,
+	"my": true, "not": true, "of": true, "on": true, "or": true, "our": true, "so": true,
+	"that": true, "the": true, "their": true, "there": true, "they": true, "this": true,
+	"to": true, "very": true, "was": true, "we": true, "were": true, "what": true,
+	"when": true, "which": true, "with": true, "you": true, "your": true,
+}
+
+func NewLexiconScorer() *LexiconScorer {
+	return &LexiconScorer{}
+}
+
+func (s *LexiconScorer) Name() string {
+	return "lexicon"
+}
+
+func (s *LexiconScorer) Healthy(ctx context.Context) error {
+	return nil
+}
+
+func (s *LexiconScorer) Score(ctx context.Context, text string) (internal.RatingResponse, error) {
+	words := tokenize(text)
+
+	for _, word := range words {
+		if profaneWords[word] {
+			return internal.RatingResponse{TextReview: text, Status: false, Rating: 0, Language: "unknown"}, ErrRejected
+		}
+	}
+
+	polarity := lexiconPolarity(words)
+
+	return internal.RatingResponse{
+		TextReview: text,
+		Status:     true,
+		Rating:     polarityToStars(polarity),
+		Sentiment:  sentimentLabel(polarity),
+		Polarity:   polarity,
+		Language:   "unknown",
+		Keywords:   keywords(words, 5),
+		Aspects:    aspectRatings(text),
+	}, nil
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
+
+// lexiconPolarity averages the scores of known words in [-1, 1]. A negator flips
+// the next scored word within three words, an intensifier right before it scales it.
+func lexiconPolarity(words []string) float64 {
+	var sum float64
+	var count int
+
+	negateWithin := 0
+	for i, word := range words {
+		if negators[word] {
+			negateWithin = 3
+			continue
+		}
+
+		score, ok := sentimentLexicon[word]
+		if ok {
+			if i > 0 {
+				if factor, ok := intensifiers[words[i-1]]; ok {
+					score *= factor
+				}
+			}
+			if negateWithin > 0 {
+				score = -score * 0.7
+				negateWithin = 0
+			}
+
+			sum += score
+			count++
+		}
+
+		if negateWithin > 0 {
+			negateWithin--
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return math.Max(-1, math.Min(1, sum/float64(count)))
+}
+
+// aspectRatings rates each aspect mentioned in the text by the polarity of the
+// sentences that mention it, the same way the NLP service does.
+func aspectRatings(text string) map[string]float64 {
+	sums := map[string]float64{}
+	counts := map[string]int{}
+
+	sentences := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '.' || r == '!' || r == '?' || r == '\n'
+	})
+	for _, sentence := range sentences {
+		words := tokenize(sentence)
+		for aspect, keywords := range aspectWords {
+			for _, word := range words {
+				if keywords[word] {
+					sums[aspect] += lexiconPolarity(words)
+					counts[aspect]++
+					break
+				}
+			}
+		}
+	}
+
+	aspects := make(map[string]float64, len(counts))
+	for aspect, count := range counts {
+		aspects[aspect] = polarityToStars(sums[aspect] / float64(count))
+	}
+
+	return aspects
+}
+
+// polarityToStars uses the same 1-5 scale as the NLP service.
+func polarityToStars(polarity float64) float64 {
+	rating := math.Round((polarity+1)*2) + 1
+	return math.Max(1, math.Min(5, rating))
+}
+
+func sentimentLabel(polarity float64) string {
+	if polarity > 0.1 {
+		return "positive"
+	}
+	if polarity < -0.1 {
+		return "negative"
+	}
+	return "neutral"
+}
+
+func keywords(words []string, limit int) []string {
+	counts := map[string]int{}
+	for _, word := range words {
+		if len(word) < 3 || stopwords[word] || strings.ContainsRune(word, '\'') {
+			continue
+		}
+		counts[word]++
+	}
+
+	result := make([]string, 0, len(counts))
+	for word := range counts {
+		result = append(result, word)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if counts[result[i]] != counts[result[j]] {
+			return counts[result[i]] > counts[result[j]]
+		}
+		return result[i] < result[j]
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
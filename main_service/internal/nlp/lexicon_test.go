@@ -0,0 +1,77 @@
+package nlp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestLexiconScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		polarity  float64
+		rating    float64
+		sentiment string
+		aspects   map[string]float64
+	}{
+		{"empty", "", 0, 3, "neutral", map[string]float64{}},
+		{"unknown words", "we ate here on tuesday", 0, 3, "neutral", map[string]float64{}},
+		{"positive", "good", 0.7, 4, "positive", map[string]float64{}},
+		{"negative", "bad", -0.7, 2, "negative", map[string]float64{}},
+		{"negated", "not good", -0.49, 2, "negative", map[string]float64{}},
+		{"never negates", "never good", -0.49, 2, "negative", map[string]float64{}},
+		{"negated within three words", "not at all good", -0.49, 2, "negative", map[string]float64{}},
+		{"negation runs out", "not at all the good", 0.7, 4, "positive", map[string]float64{}},
+		{"intensified", "very good", 0.91, 5, "positive", map[string]float64{}},
+		{"intensified and negated", "not very good", -0.637, 2, "negative", map[string]float64{}},
+		{"clamped", "extremely excellent", 1, 5, "positive", map[string]float64{}},
+		{"averaged", "good but bad", 0, 3, "neutral", map[string]float64{}},
+		{
+			"aspects", "The food was delicious. The waiter was very rude.",
+			0.01, 3, "neutral", map[string]float64{"food": 5, "service": 1},
+		},
+	}
+
+	scorer := NewLexiconScorer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rating, err := scorer.Score(context.Background(), tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !rating.Status {
+				t.Error("status = false, want true")
+			}
+			if math.Abs(rating.Polarity-tt.polarity) > 1e-9 {
+				t.Errorf("polarity = %v, want %v", rating.Polarity, tt.polarity)
+			}
+			if rating.Rating != tt.rating {
+				t.Errorf("rating = %v, want %v", rating.Rating, tt.rating)
+			}
+			if rating.Sentiment != tt.sentiment {
+				t.Errorf("sentiment = %q, want %q", rating.Sentiment, tt.sentiment)
+			}
+			if len(rating.Aspects) != len(tt.aspects) {
+				t.Errorf("aspects = %v, want %v", rating.Aspects, tt.aspects)
+			}
+			for aspect, want := range tt.aspects {
+				if got, ok := rating.Aspects[aspect]; !ok || got != want {
+					t.Errorf("aspect %s = %v, want %v", aspect, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLexiconScoreProfanity(t *testing.T) {
+	rating, err := NewLexiconScorer().Score(context.Background(), "This place is Shit")
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("err = %v, want %v", err, ErrRejected)
+	}
+	if rating.Status {
+		t.Error("status = true, want false")
+	}
+}
@@ -2,71 +2,88 @@ package nlp
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
+	"restaurant_reviews/config"
 	"restaurant_reviews/internal"
-	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-func CheckMessage(text string) internal.RatingResponse {
-	nlp_url := "http://127.0.0.1:8000/rate"
+// Scorer rates review text. A review the scorer refuses, e.g. for profanity, comes
+// back with ErrRejected; ErrTimeout and ErrUnavailable are failures where asking
+// again later may give an answer.
+type Scorer interface {
+	Name() string
+	Score(ctx context.Context, text string) (internal.RatingResponse, error)
+	Healthy(ctx context.Context) error
+}
 
-	jsonStr := fmt.Sprintf(`{"text": "%s"}`, text)
-	payload := strings.NewReader(jsonStr)
+var scorer Scorer = NewLexiconScorer()
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+var retryAfter = 30 * time.Second
 
-	req, err := http.NewRequestWithContext(ctx, "POST", nlp_url, payload)
-	if err != nil {
-		return internal.RatingResponse{
-			TextReview: "Failed to create request",
-			Status:     false,
-			Rating:     0,
-		}
-	}
+var maxTextLength = 5000
 
-	req.Header.Add("Content-Type", "application/json")
+var (
+	batchSize        = 50
+	batchConcurrency = 4
+)
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return internal.RatingResponse{
-				TextReview: "NLP service timeout - request took too long",
-				Status:     false,
-				Rating:     0,
-			}
-		}
-		return internal.RatingResponse{
-			TextReview: "Failed to connect to NLP service",
-			Status:     false,
-			Rating:     0,
-		}
-	}
-	defer res.Body.Close()
+// Configure selects the scorer named by cfg.Provider: "http" for the NLP service,
+// "lexicon" for the built-in scorer, or "auto" for the service with the lexicon
+// scorer as fallback while the service is unhealthy. Health probing stops with ctx.
+func Configure(ctx context.Context, cfg config.NLPConfig) error {
+	retryAfter = cfg.BreakerCooldown
+	maxTextLength = cfg.MaxTextLength
+	batchSize = cfg.BatchSize
+	batchConcurrency = cfg.BatchConcurrency
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return internal.RatingResponse{
-			TextReview: "Failed to read response",
-			Status:     false,
-			Rating:     0,
-		}
+	switch cfg.Provider {
+	case "http":
+		scorer = newHTTPScorer(cfg)
+	case "lexicon":
+		scorer = NewLexiconScorer()
+	case "auto":
+		fallback := NewFallbackScorer(newHTTPScorer(cfg), NewLexiconScorer())
+		go fallback.Probe(ctx, cfg.HealthInterval)
+		scorer = fallback
+	default:
+		return fmt.Errorf("unknown nlp provider %q", cfg.Provider)
 	}
 
-	var rating internal.RatingResponse
-	err = json.Unmarshal(body, &rating)
-	if err != nil {
-		return internal.RatingResponse{
-			TextReview: fmt.Sprintf("Failed to parse response: %v", err),
-			Status:     false,
-			Rating:     0,
-		}
+	log.Printf("Using %s NLP scorer", scorer.Name())
+	return nil
+}
+
+func newHTTPScorer(cfg config.NLPConfig) *HTTPScorer {
+	return NewHTTPScorer(cfg.URL, cfg.Timeout, cfg.BatchTextTimeout, cfg.Retries, cfg.BreakerThreshold, cfg.BreakerCooldown)
+}
+
+// RetryAfter is how long clients should wait before asking again about a review
+// held up by the NLP service.
+func RetryAfter() time.Duration {
+	return retryAfter
+}
+
+// CheckLength returns ErrTextTooLong when the text has more characters than the
+// configured maximum.
+func CheckLength(text string) error {
+	if utf8.RuneCountInString(text) > maxTextLength {
+		return ErrTextTooLong
 	}
+	return nil
+}
 
-	return rating
+// MaxTextLength is the longest review text, in characters, that will be scored.
+func MaxTextLength() int {
+	return maxTextLength
+}
+
+// Score rates the text with the configured scorer.
+func Score(ctx context.Context, text string) (internal.RatingResponse, error) {
+	if err := CheckLength(text); err != nil {
+		return internal.RatingResponse{}, err
+	}
+	return scorer.Score(ctx, text)
 }
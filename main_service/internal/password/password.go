@@ -0,0 +1,101 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Params are the argon2id cost parameters used for new hashes.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var params = DefaultParams
+
+func SetParams(p Params) {
+	params = p
+}
+
+// Hash returns the password encoded in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func Hash(plain string) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	key := argon2.IDKey([]byte(plain), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks plain against the stored value. needsRehash is true when the password
+// matched but the stored value is legacy plaintext or was hashed with other parameters.
+func Verify(plain string, stored string) (match bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(stored, "$argon2id$") {
+		// Accounts created before hashing was introduced keep the raw password.
+		match = subtle.ConstantTimeCompare([]byte(plain), []byte(stored)) == 1
+		return match, match, nil
+	}
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, false, fmt.Errorf("invalid password hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, fmt.Errorf("invalid password hash version: %v", err)
+	}
+
+	var p Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return false, false, fmt.Errorf("invalid password hash parameters: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid password hash salt: %v", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid password hash: %v", err)
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	candidate := argon2.IDKey([]byte(plain), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash = version != argon2.Version || p != params
+	return true, needsRehash, nil
+}
@@ -0,0 +1,102 @@
+package scoring
+
+import (
+	"fmt"
+	"math"
+	"restaurant_reviews/config"
+)
+
+// RatingPolicy turns the user's stars and the NLP rating into the rating a review
+// counts with. Version names the policy and its parameters; it is stored with each
+// review so ratings made under an older policy can be found and recomputed.
+type RatingPolicy interface {
+	Version() string
+	Rate(userRating float64, nlpRating float64) float64
+}
+
+// WeightedPolicy mixes UserWeight of the user's stars with the rest from NLP.
+type WeightedPolicy struct {
+	UserWeight float64
+}
+
+func (p WeightedPolicy) Version() string {
+	return fmt.Sprintf("weighted:%g", p.UserWeight)
+}
+
+func (p WeightedPolicy) Rate(userRating float64, nlpRating float64) float64 {
+	return clampRating(userRating*p.UserWeight + nlpRating*(1-p.UserWeight))
+}
+
+// UserOnlyPolicy ignores the NLP rating.
+type UserOnlyPolicy struct{}
+
+func (UserOnlyPolicy) Version() string {
+	return "user"
+}
+
+func (UserOnlyPolicy) Rate(userRating float64, nlpRating float64) float64 {
+	return clampRating(userRating)
+}
+
+// NLPOnlyPolicy ignores the user's stars.
+type NLPOnlyPolicy struct{}
+
+func (NLPOnlyPolicy) Version() string {
+	return "nlp"
+}
+
+func (NLPOnlyPolicy) Rate(userRating float64, nlpRating float64) float64 {
+	return clampRating(nlpRating)
+}
+
+// PenalisedPolicy is a weighted mix that loses Penalty stars for every star the two
+// ratings differ by beyond Threshold, so reviews whose text contradicts their stars
+// count for less.
+type PenalisedPolicy struct {
+	UserWeight float64
+	Threshold  float64
+	Penalty    float64
+}
+
+func (p PenalisedPolicy) Version() string {
+	return fmt.Sprintf("penalised:%g:%g:%g", p.UserWeight, p.Threshold, p.Penalty)
+}
+
+func (p PenalisedPolicy) Rate(userRating float64, nlpRating float64) float64 {
+	rating := userRating*p.UserWeight + nlpRating*(1-p.UserWeight)
+
+	if excess := math.Abs(userRating-nlpRating) - p.Threshold; excess > 0 {
+		rating -= excess * p.Penalty
+	}
+
+	return clampRating(rating)
+}
+
+func clampRating(rating float64) float64 {
+	return math.Max(0, math.Min(5, rating))
+}
+
+var policy RatingPolicy = WeightedPolicy{UserWeight: 0.7}
+
+// Configure selects the rating policy named by cfg.Policy.
+func Configure(cfg config.RatingConfig) error {
+	switch cfg.Policy {
+	case "weighted":
+		policy = WeightedPolicy{UserWeight: cfg.UserWeight}
+	case "user":
+		policy = UserOnlyPolicy{}
+	case "nlp":
+		policy = NLPOnlyPolicy{}
+	case "penalised":
+		policy = PenalisedPolicy{UserWeight: cfg.UserWeight, Threshold: cfg.DisagreementThreshold, Penalty: cfg.Penalty}
+	default:
+		return fmt.Errorf("unknown rating policy %q", cfg.Policy)
+	}
+
+	return nil
+}
+
+// Policy returns the configured rating policy.
+func Policy() RatingPolicy {
+	return policy
+}
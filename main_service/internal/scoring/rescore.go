@@ -0,0 +1,214 @@
+package scoring
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/nlp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// rescorePageSize is how many reviews are read and handed to nlp.ScoreBatch at once.
+const rescorePageSize = 500
+
+// rescoreLease is how long a rescore job stays locked to this process without being
+// renewed. It is renewed three times per lease while the job runs.
+const rescoreLease = time.Minute
+
+// instanceID names this process as the owner of the rescore jobs it runs.
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// StartRescore stores the job as owned by this process and runs it in the
+// background until it is done or the workers stop. It fails with
+// database.ErrRescoreRunning while another job is running.
+func StartRescore(job internal.RescoreJob) (internal.RescoreJob, error) {
+	job.Owner = instanceID
+
+	job, err := database.CreateRescoreJob(job, rescoreLease)
+	if err != nil {
+		return job, err
+	}
+
+	go Rescore(runCtx, job)
+	return job, nil
+}
+
+// keepRescoreLocked renews the job's lock until ctx is done. When the job was
+// taken from this process it cancels the run.
+func keepRescoreLocked(ctx context.Context, cancel context.CancelFunc, job internal.RescoreJob) {
+	ticker := time.NewTicker(rescoreLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := database.RenewRescoreJob(job.ID, job.Owner, rescoreLease)
+		if err == mongo.ErrNoDocuments {
+			log.Printf("Rescore job %s is no longer held by this process, stopping", job.ID)
+			cancel()
+			return
+		}
+		if err != nil {
+			log.Printf("Rescore job %s: %v", job.ID, err)
+		}
+	}
+}
+
+// Rescore runs the job over every published review and then rebuilds the restaurant
+// aggregates. In nlp mode the texts are scored again and the new NLP results stored;
+// reviews the scorer now refuses keep their rating and are counted as failed, and
+// the job fails with the error when the scorer can't be reached. In policy mode
+// only reviews rated under another policy are updated, from their stored ratings.
+// Progress is recorded on the job as each page is done, and its lock renewed while
+// it runs.
+func Rescore(ctx context.Context, job internal.RescoreJob) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go keepRescoreLocked(ctx, cancel, job)
+
+	err := rescore(ctx, job)
+	if err == nil {
+		err = database.RebuildRatings()
+	}
+	if err != nil {
+		log.Printf("Rescore job %s failed: %v", job.ID, err)
+	}
+
+	if err := database.FinishRescoreJob(job.ID, err); err != nil {
+		log.Printf("Rescore job %s: %v", job.ID, err)
+	}
+}
+
+func rescore(ctx context.Context, job internal.RescoreJob) error {
+	lastID := ""
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		reviews, err := database.GetPublishedReviews(lastID, rescorePageSize)
+		if err != nil {
+			return err
+		}
+		if len(reviews) == 0 {
+			return nil
+		}
+		lastID = reviews[len(reviews)-1].ID
+
+		var failed int64
+		if job.Mode == internal.RescorePolicy {
+			failed = reapplyPolicy(job, reviews)
+		} else {
+			failed, err = rescoreTexts(ctx, job, reviews)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = database.UpdateRescoreProgress(job.ID, int64(len(reviews)), failed)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rescoreTexts scores the reviews again and returns how many couldn't be updated.
+// When the scorer fails the page is left as it was and the error returned, so the
+// job fails rather than storing scores from a fallback.
+func rescoreTexts(ctx context.Context, job internal.RescoreJob, reviews []internal.Review) (int64, error) {
+	texts := make([]string, len(reviews))
+	for i, review := range reviews {
+		texts[i] = review.Text
+	}
+
+	results, err := nlp.ScoreBatch(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+
+	var failed int64
+	for i, result := range results {
+		if result.Err != nil {
+			failed++
+			continue
+		}
+
+		err := saveRescore(reviews[i], result.Rating)
+		if err != nil {
+			log.Printf("Rescore job %s: review %s: %v", job.ID, reviews[i].ID, err)
+			failed++
+		}
+	}
+
+	return failed, nil
+}
+
+// reapplyPolicy rates reviews from another policy again with the current one and
+// returns how many couldn't be updated. Reviews scored before the NLP rating was
+// stored need a rescore in nlp mode.
+func reapplyPolicy(job internal.RescoreJob, reviews []internal.Review) int64 {
+	ratingPolicy := Policy()
+
+	var failed int64
+	for _, review := range reviews {
+		if review.PolicyVersion == ratingPolicy.Version() {
+			continue
+		}
+		if review.NLPRating == nil {
+			failed++
+			continue
+		}
+
+		_, err := database.RescoreReview(review.ID, review.Revision, internal.ReviewScore{
+			Rating:        ratingPolicy.Rate(review.UserRating, *review.NLPRating),
+			NLPRating:     *review.NLPRating,
+			PolicyVersion: ratingPolicy.Version(),
+			Aspects:       review.Aspects,
+		})
+		if err != nil {
+			log.Printf("Rescore job %s: review %s: %v", job.ID, review.ID, err)
+			failed++
+		}
+	}
+
+	return failed
+}
+
+func saveRescore(review internal.Review, nlpReview internal.RatingResponse) error {
+	updated, err := database.RescoreReview(review.ID, review.Revision, scoreReview(review.UserRating, review.UserAspects, nlpReview))
+	if err != nil || !updated {
+		// An edited review was queued for scoring with its new text
+		return err
+	}
+
+	return database.SaveNLPResult(internal.NLPResult{
+		ReviewID:   review.ID,
+		Sentiment:  nlpReview.Sentiment,
+		Polarity:   nlpReview.Polarity,
+		Language:   nlpReview.Language,
+		Keywords:   nlpReview.Keywords,
+		Rating:     nlpReview.Rating,
+		Aspects:    nlpReview.Aspects,
+		AnalyzedAt: time.Now().UTC(),
+	})
+}
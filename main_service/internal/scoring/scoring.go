@@ -0,0 +1,128 @@
+package scoring
+
+import (
+	"context"
+	"errors"
+	"log"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/nlp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// scoreReview rates a review with the configured policy and fills in the aspects
+// the user didn't rate from the NLP analysis.
+func scoreReview(userRating float64, userAspects map[string]float64, nlpReview internal.RatingResponse) internal.ReviewScore {
+	ratingPolicy := Policy()
+
+	var aspects map[string]float64
+	for _, aspect := range internal.Aspects {
+		value, ok := userAspects[aspect]
+		if !ok {
+			value, ok = nlpReview.Aspects[aspect]
+		}
+		if !ok {
+			continue
+		}
+		if aspects == nil {
+			aspects = map[string]float64{}
+		}
+		aspects[aspect] = value
+	}
+
+	return internal.ReviewScore{
+		Rating:        ratingPolicy.Rate(userRating, nlpReview.Rating),
+		NLPRating:     nlpReview.Rating,
+		PolicyVersion: ratingPolicy.Version(),
+		Aspects:       aspects,
+	}
+}
+
+// UserScore rates a review from the user's own stars and aspects alone, for reviews
+// a moderator publishes without an NLP rating.
+func UserScore(review internal.Review) internal.ReviewScore {
+	userPolicy := UserOnlyPolicy{}
+
+	return internal.ReviewScore{
+		Rating:        userPolicy.Rate(review.UserRating, 0),
+		PolicyVersion: userPolicy.Version(),
+		Aspects:       review.UserAspects,
+	}
+}
+
+// Process scores the review revision named by the job and publishes it with the
+// rating given by the configured policy. When the text is refused the review is
+// held for moderation, when it is too long it is rejected, and in both cases
+// the scorer's error is returned.
+func Process(ctx context.Context, job internal.NLPJob) error {
+	review, err := database.GetReview(job.ReviewID)
+	if err == mongo.ErrNoDocuments {
+		// Deleted while queued, nothing left to do
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if review.Revision != job.Revision {
+		return nil
+	}
+	// An earlier attempt published the revision but failed to count it
+	if review.Status == internal.ReviewPublished && review.Uncounted {
+		_, err := database.CountReview(review.ID, job.Revision)
+		return err
+	}
+	if review.Status != internal.ReviewPendingAnalysis {
+		return nil
+	}
+
+	nlpReview, err := nlp.Score(ctx, review.Text)
+	if errors.Is(err, nlp.ErrRejected) {
+		_, holdErr := database.HoldReview(review.ID, job.Revision, internal.ModerationProfanity)
+		if holdErr != nil {
+			return holdErr
+		}
+		return err
+	}
+	// Text over the length limit predates the guard in the handlers and can never
+	// be scored
+	if errors.Is(err, nlp.ErrTextTooLong) {
+		_, rejectErr := database.RejectReview(review.ID, job.Revision)
+		if rejectErr != nil {
+			return rejectErr
+		}
+		return err
+	}
+	if err != nil {
+		// Let clients tell "still queued" from "waiting for the NLP service"
+		if kind := nlp.ErrorKind(err); kind != "" {
+			if markErr := database.SetReviewAnalysisError(review.ID, job.Revision, kind); markErr != nil {
+				log.Printf("Failed to record analysis error for review %s: %v", review.ID, markErr)
+			}
+		}
+		return err
+	}
+
+	// Saved first so a retry after a failed publish scores and saves it again
+	err = database.SaveNLPResult(internal.NLPResult{
+		ReviewID:   review.ID,
+		Sentiment:  nlpReview.Sentiment,
+		Polarity:   nlpReview.Polarity,
+		Language:   nlpReview.Language,
+		Keywords:   nlpReview.Keywords,
+		Rating:     nlpReview.Rating,
+		Aspects:    nlpReview.Aspects,
+		AnalyzedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.PublishReview(review.ID, job.Revision, scoreReview(job.UserRating, review.UserAspects, nlpReview))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package scoring
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"restaurant_reviews/config"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/nlp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const pollInterval = time.Second
+
+// runCtx is the context the workers were started with, background jobs stop with it.
+var runCtx = context.Background()
+
+// StartWorkers runs cfg.Workers goroutines that score queued reviews until ctx is
+// cancelled.
+func StartWorkers(ctx context.Context, cfg config.NLPConfig) {
+	runCtx = ctx
+	for i := 0; i < cfg.Workers; i++ {
+		go work(ctx, i, cfg)
+	}
+}
+
+func work(ctx context.Context, id int, cfg config.NLPConfig) {
+	// A job is locked for longer than one NLP call may take with all its retries,
+	// so a live worker never loses its job to another one.
+	lease := nlp.CallBudget(cfg.Timeout, cfg.Retries) + 30*time.Second
+
+	for {
+		job, err := database.ClaimNLPJob(lease)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("NLP worker %d: failed to claim job: %v", id, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		handle(ctx, job, cfg)
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func handle(ctx context.Context, job internal.NLPJob, cfg config.NLPConfig) {
+	err := Process(ctx, job)
+	if err == nil || errors.Is(err, nlp.ErrRejected) || errors.Is(err, nlp.ErrTextTooLong) {
+		if err := database.CompleteNLPJob(job); err != nil {
+			log.Printf("NLP job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	// The service wasn't even called, so the attempt doesn't count
+	if errors.Is(err, nlp.ErrCircuitOpen) {
+		if err := database.PostponeNLPJob(job, time.Now().UTC().Add(cfg.BreakerCooldown)); err != nil {
+			log.Printf("NLP job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	if job.Attempts >= cfg.MaxAttempts {
+		log.Printf("NLP job %s for review %s failed %d times, moving to dead letters: %v", job.ID, job.ReviewID, job.Attempts, err)
+		if err := database.DeadLetterNLPJob(job, err.Error()); err != nil {
+			log.Printf("NLP job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	delay := backoff(job.Attempts, cfg.RetryBackoff, cfg.MaxRetryBackoff)
+	log.Printf("NLP job %s for review %s failed (attempt %d), retrying in %s: %v", job.ID, job.ReviewID, job.Attempts, delay, err)
+	if err := database.RetryNLPJob(job, time.Now().UTC().Add(delay), err.Error()); err != nil {
+		log.Printf("NLP job %s: %v", job.ID, err)
+	}
+}
+
+// backoff doubles the delay with every attempt, starting at base and capped at max.
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := time.Duration(float64(base) * math.Pow(2, float64(attempt-1)))
+	if delay <= 0 || delay > max {
+		return max
+	}
+
+	return delay
+}
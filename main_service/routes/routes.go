@@ -1,39 +1,191 @@
 package routes
 
 import (
-	"fmt"
+	"log"
 	"net/http"
+	"restaurant_reviews/database"
+	"restaurant_reviews/internal"
+	"restaurant_reviews/internal/audit"
 	"restaurant_reviews/internal/handlers"
 	"restaurant_reviews/internal/jwtAuth"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func AuthMidleware() gin.HandlerFunc {
+// maxRequestIDLength caps request IDs taken from the X-Request-ID header.
+const maxRequestIDLength = 64
+
+// AuthMiddleware rejects requests without a valid token and stores its claims in
+// the context. The token is read from the Authorization header or the jwt cookie.
+func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		fmt.Println("AuthMidleware")
-		tokenString, err := c.Request.Cookie("jwt")
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if tokenString == "" {
+			cookie, err := c.Request.Cookie("jwt")
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+				return
+			}
+			tokenString = cookie.Value
+		}
+
+		claims, err := jwtAuth.ParseToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
 		}
 
-		jwtAuth.VerifyToken(tokenString.Value)
+		revoked, err := database.IsAccessTokenRevoked(claims.ID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify token"})
+			return
+		}
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+
+		c.Set(jwtAuth.ClaimsKey, claims)
 		c.Next()
 	}
 }
 
+// RequireRole only lets through users whose token carries one of the given roles.
+// It must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := jwtAuth.GetClaims(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		for _, role := range roles {
+			if claims.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+	}
+}
+
+// AuditMiddleware writes every request it sees to the admin log, with the response
+// status and whatever the handler recorded through the audit package. GET, HEAD and
+// OPTIONS requests are logged as reads so they can be filtered out. The request ID
+// is taken from the X-Request-ID header or generated, and sent back in it. It must
+// run after AuthMiddleware.
+func AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = primitive.NewObjectID().Hex()
+		}
+		c.Header("X-Request-ID", requestID)
+
+		action := internal.AdminLogWrite
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			action = internal.AdminLogRead
+		}
+
+		claims, _ := jwtAuth.GetClaims(c)
+		entry := &internal.AdminLog{
+			ID:         primitive.NewObjectID().Hex(),
+			AdminID:    claims.UserID,
+			Action:     action,
+			ActionType: c.Request.Method + " " + c.FullPath(),
+			RequestID:  requestID,
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			ClientIP:   c.ClientIP(),
+			CreatedAt:  time.Now().UTC(),
+		}
+		c.Set(audit.EntryKey, entry)
+
+		c.Next()
+
+		entry.Status = c.Writer.Status()
+		if err := database.CreateAdminLog(*entry); err != nil {
+			log.Printf("Failed to audit request %s: %v", requestID, err)
+		}
+	}
+}
+
 func SetupRoutes() *gin.Engine {
 	router := gin.Default()
 	loggedin := router.Group("/")
-	loggedin.Use(AuthMidleware())
+	loggedin.Use(AuthMiddleware())
 	{
 		loggedin.GET("/user", handlers.GetUserHandler)
+		loggedin.POST("/user/logout", handlers.LogoutHandler)
 		loggedin.POST("/user/feedback", handlers.FeedBackHandler)
-		loggedin.DELETE("/user/:id", handlers.DeleteUserHandler)
+		loggedin.GET("/user/favorites", handlers.GetFavoritesHandler)
+		loggedin.POST("/user/favorites/:restaurantId", handlers.AddFavoriteHandler)
+		loggedin.DELETE("/user/favorites/:restaurantId", handlers.RemoveFavoriteHandler)
+
+		loggedin.GET("/user/collections", handlers.GetUserCollectionsHandler)
+		loggedin.POST("/user/collections", handlers.CreateCollectionHandler)
+		loggedin.GET("/user/collections/:id", handlers.GetUserCollectionHandler)
+		loggedin.PUT("/user/collections/:id", handlers.UpdateCollectionHandler)
+		loggedin.DELETE("/user/collections/:id", handlers.DeleteCollectionHandler)
+		loggedin.POST("/user/collections/:id/share", handlers.ShareCollectionHandler)
+		loggedin.PUT("/user/collections/:id/order", handlers.ReorderCollectionHandler)
+		loggedin.PUT("/user/collections/:id/restaurants/:restaurantId", handlers.SetCollectionEntryHandler)
+		loggedin.DELETE("/user/collections/:id/restaurants/:restaurantId", handlers.RemoveCollectionEntryHandler)
+		loggedin.POST("/collections/:id/copy", handlers.CopyCollectionHandler)
+		loggedin.PUT("/reviews/:id", handlers.UpdateReviewHandler)
+		loggedin.DELETE("/reviews/:id", handlers.DeleteReviewHandler)
+		loggedin.POST("/reviews/:id/flag", handlers.FlagReviewHandler)
+	}
+
+	admin := loggedin.Group("/")
+	admin.Use(RequireRole("admin"), AuditMiddleware())
+	{
+		admin.DELETE("/user/:id", handlers.DeleteUserHandler)
+
+		admin.POST("/restaurants", handlers.CreateRestaurantHandler)
+		admin.PUT("/restaurants/:id", handlers.UpdateRestaurantHandler)
+		admin.DELETE("/restaurants/:id", handlers.DeleteRestaurantHandler)
+
+		admin.POST("/categories", handlers.CreateCategoryHandler)
+		admin.PUT("/categories/:id", handlers.RenameCategoryHandler)
+		admin.POST("/categories/:id/merge", handlers.MergeCategoryHandler)
+		admin.DELETE("/categories/:id", handlers.DeleteCategoryHandler)
+
+		admin.POST("/admin/reviews/rescore", handlers.RescoreReviewsHandler)
+		admin.GET("/admin/reviews/rescore/:id", handlers.GetRescoreJobHandler)
+
+		admin.GET("/admin/reviews/moderation", handlers.GetModerationQueueHandler)
+		admin.POST("/admin/reviews/:id/moderation", handlers.ModerateReviewHandler)
+		admin.POST("/admin/reviews/:id/requeue", handlers.RequeueReviewHandler)
+
+		admin.GET("/admin/audit", handlers.GetAuditLogHandler)
 	}
 
+	router.GET("/.well-known/jwks.json", handlers.JWKSHandler)
+
 	router.POST("/user/register", handlers.RegisterHandler)
 	router.POST("/user/login", handlers.LoginHandler)
+	router.POST("/user/refresh", handlers.RefreshHandler)
+
+	router.GET("/restaurants", handlers.GetRestaurantsHandler)
+	router.GET("/restaurants/nearby", handlers.GetNearbyRestaurantsHandler)
+	router.GET("/restaurants/top", handlers.GetTopRestaurantsHandler)
+	router.GET("/restaurants/:id", handlers.GetRestaurantHandler)
+	router.GET("/restaurants/:id/reviews", handlers.GetRestaurantReviewsHandler)
+	router.GET("/restaurants/:id/stats", handlers.GetRestaurantStatsHandler)
+	router.GET("/reviews/:id/analysis", handlers.GetReviewAnalysisHandler)
+
+	router.GET("/collections", handlers.GetPublicCollectionsHandler)
+	router.GET("/collections/shared/:token", handlers.GetSharedCollectionHandler)
+
+	router.GET("/categories", handlers.GetCategoriesHandler)
+	router.GET("/categories/:id/restaurants", handlers.GetCategoryRestaurantsHandler)
 
 	return router
 }
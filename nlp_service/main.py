@@ -1,5 +1,8 @@
+import os
+from typing import Annotated
+
 from fastapi import FastAPI, HTTPException
-from pydantic import BaseModel
+from pydantic import BaseModel, Field
 from deep_translator import GoogleTranslator
 from textblob import TextBlob
 from langdetect import detect
@@ -10,8 +13,17 @@ profanity.load_censor_words()
 
 app = FastAPI(title="NLP Review Rating API")
 
+# Shared with the main service, which refuses longer text before sending it
+MAX_TEXT_LENGTH = int(os.environ.get("NLP_MAX_TEXT_LENGTH", "5000"))
+MAX_BATCH_SIZE = 100
+
+
 class ReviewRequest(BaseModel):
-    text: str
+    text: str = Field(max_length=MAX_TEXT_LENGTH)
+
+
+class BatchReviewRequest(BaseModel):
+    texts: list[Annotated[str, Field(max_length=MAX_TEXT_LENGTH)]] = Field(max_length=MAX_BATCH_SIZE)
 
 
 def is_profane(text: str) -> bool:
@@ -21,24 +33,70 @@ def is_profane(text: str) -> bool:
         return False
 
 
-def get_star_rating_auto(text: str) -> int:
-    try:
-        lang = detect(text)
-        translated = GoogleTranslator(source=lang, target='en').translate(text) if lang != 'en' else text
-        polarity = TextBlob(translated).sentiment.polarity
-        rating = (polarity + 1) * 2
-        return max(1, min(5, round(rating)+1))
-    except Exception as e:
-        raise HTTPException(status_code=500, detail=f"NLP помилка: {str(e)}")
+STOPWORDS = {
+    "a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "from", "had", "has",
+    "have", "i", "in", "is", "it", "its", "me", "my", "not", "of", "on", "or", "our",
+    "so", "that", "the", "their", "there", "they", "this", "to", "very", "was", "we",
+    "were", "what", "when", "which", "with", "you", "your",
+}
 
 
-@app.post("/rate")
-async def rate_review(request: ReviewRequest):
-    original_text = request.text
+ASPECT_KEYWORDS = {
+    "food": {"food", "dish", "dishes", "meal", "taste", "flavor", "flavour", "menu", "pizza", "pasta",
+             "burger", "steak", "soup", "salad", "dessert", "portion", "portions", "cooked", "delicious", "tasty"},
+    "service": {"service", "staff", "waiter", "waitress", "server", "waiters", "host", "manager",
+                "friendly", "rude", "polite", "attentive", "wait", "waited"},
+    "ambience": {"ambience", "ambiance", "atmosphere", "decor", "music", "interior", "noisy", "quiet",
+                 "cozy", "romantic", "view", "place", "room", "vibe"},
+    "value": {"price", "prices", "value", "expensive", "cheap", "overpriced", "worth", "bill",
+              "cost", "affordable", "money"},
+}
+
+
+def extract_aspects(text: str) -> dict[str, float]:
+    """Rates each aspect mentioned in the text by the polarity of the sentences that mention it."""
+    polarities: dict[str, list[float]] = {}
+    for sentence in TextBlob(text).sentences:
+        words = {word.lower() for word in sentence.words}
+        for aspect, keywords in ASPECT_KEYWORDS.items():
+            if words & keywords:
+                polarities.setdefault(aspect, []).append(sentence.sentiment.polarity)
+    return {aspect: float(polarity_to_stars(sum(values) / len(values))) for aspect, values in polarities.items()}
+
+
+def polarity_to_stars(polarity: float) -> int:
+    rating = (polarity + 1) * 2
+    return max(1, min(5, round(rating)+1))
+
+
+def sentiment_label(polarity: float) -> str:
+    if polarity > 0.1:
+        return "positive"
+    if polarity < -0.1:
+        return "negative"
+    return "neutral"
+
+
+def extract_keywords(text: str, limit: int = 5) -> list[str]:
+    counts: dict[str, int] = {}
+    for word in TextBlob(text.lower()).words:
+        if len(word) < 3 or word in STOPWORDS or not word.isalpha():
+            continue
+        counts[word] = counts.get(word, 0) + 1
+    return [word for word, _ in sorted(counts.items(), key=lambda item: (-item[1], item[0]))[:limit]]
+
+
+@app.get("/health")
+async def health():
+    return {"status": "ok"}
+
+
+def rate_text(original_text: str) -> dict:
     try:
         lang = detect(original_text)
         translated = GoogleTranslator(source=lang, target='en').translate(original_text) if lang != 'en' else original_text
     except Exception:
+        lang = "unknown"
         translated = original_text
 
 
@@ -46,12 +104,35 @@ async def rate_review(request: ReviewRequest):
         return {
             "review": original_text,
             "status": False,
-            "rating": 0
+            "rating": 0,
+            "language": lang
         }
 
-    rating = get_star_rating_auto(original_text)
+    try:
+        polarity = TextBlob(translated).sentiment.polarity
+        aspects = extract_aspects(translated)
+    except Exception as e:
+        raise HTTPException(status_code=500, detail=f"NLP помилка: {str(e)}")
+
     return {
         "review": original_text,
         "status": True,
-        "rating": float(rating)
+        "rating": float(polarity_to_stars(polarity)),
+        "sentiment": sentiment_label(polarity),
+        "polarity": polarity,
+        "language": lang,
+        "keywords": extract_keywords(translated),
+        "aspects": aspects
     }
+
+
+# Plain def endpoints run in FastAPI's thread pool, so the blocking translation
+# calls don't hold up other requests
+@app.post("/rate")
+def rate_review(request: ReviewRequest):
+    return rate_text(request.text)
+
+
+@app.post("/rate/batch")
+def rate_reviews(request: BatchReviewRequest):
+    return {"results": [rate_text(text) for text in request.texts]}